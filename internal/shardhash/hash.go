@@ -0,0 +1,51 @@
+// Package shardhash holds the key hashing shared by the sharded locks, so
+// that every package maps a key to the same shard for the same shard count.
+package shardhash
+
+// Hasher maps keys to 64-bit hashes used to select a shard.
+// Implementations must be deterministic and safe for concurrent use.
+type Hasher interface {
+	HashString(key string) uint64
+	HashBytes(key []byte) uint64
+	HashUint64(key uint64) uint64
+}
+
+// FNV1a is the default Hasher. It hashes strings and byte slices with
+// 64-bit FNV-1a and mixes integer keys with the SplitMix64 finalizer.
+// It does not allocate and gives the same result in every process.
+type FNV1a struct{}
+
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+// HashString hashes a string key.
+func (FNV1a) HashString(key string) uint64 {
+	h := uint64(fnvOffset64)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= fnvPrime64
+	}
+	return h
+}
+
+// HashBytes hashes a byte slice key.
+func (FNV1a) HashBytes(key []byte) uint64 {
+	h := uint64(fnvOffset64)
+	for _, b := range key {
+		h ^= uint64(b)
+		h *= fnvPrime64
+	}
+	return h
+}
+
+// HashUint64 hashes an integer key.
+func (FNV1a) HashUint64(key uint64) uint64 {
+	key ^= key >> 30
+	key *= 0xbf58476d1ce4e5b9
+	key ^= key >> 27
+	key *= 0x94d049bb133111eb
+	key ^= key >> 31
+	return key
+}
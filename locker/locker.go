@@ -0,0 +1,43 @@
+// Package cxlocker defines the lock interfaces shared by the golock
+// packages, so that code can take an in-process shard of a ShardedRWLock or
+// a cross-process semaphore interchangeably, chosen by configuration. The
+// lockertest package checks an implementation against the contract.
+package cxlocker
+
+import "context"
+
+// Locker is an exclusive lock whose operations report failures.
+type Locker interface {
+	// Lock acquires the lock, waiting as long as necessary.
+	Lock() error
+	// Unlock releases the lock.
+	Unlock() error
+	// TryLock acquires the lock if that is possible without waiting. It
+	// returns false with a nil error when the lock is held elsewhere.
+	TryLock() (bool, error)
+	// LockContext acquires the lock, waiting until ctx is done. It returns
+	// ctx.Err() if the lock could not be acquired in time, in which case
+	// the lock is not held. A context that is already done never acquires
+	// the lock.
+	LockContext(ctx context.Context) error
+	// Close releases the resources behind the lock. It must not be held.
+	Close() error
+}
+
+// RWLocker is a Locker that can also be held in shared mode. Shared holders
+// exclude exclusive ones. Whether shared holders admit each other depends on
+// the implementation: a semaphore has a single mode, and its shared
+// operations behave like the exclusive ones.
+type RWLocker interface {
+	Locker
+	// RLock acquires the lock in shared mode.
+	RLock() error
+	// RUnlock releases a shared hold.
+	RUnlock() error
+	// TryRLock acquires the lock in shared mode if that is possible without
+	// waiting.
+	TryRLock() (bool, error)
+	// RLockContext acquires the lock in shared mode, waiting until ctx is
+	// done, like LockContext.
+	RLockContext(ctx context.Context) error
+}
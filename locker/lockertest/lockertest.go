@@ -0,0 +1,296 @@
+// Package lockertest checks implementations of the cxlocker interfaces
+// against their contract. Call it from a test of the implementation:
+//
+//	func TestLocker(t *testing.T) {
+//		lockertest.Run(t, func(t *testing.T) cxlocker.RWLocker { return newLocker(t) })
+//	}
+package lockertest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cxlocker "github.com/cloudxaas/golock/locker"
+)
+
+// Factory returns a new, unlocked locker for one subtest. Use t.Cleanup for
+// anything beyond the locker's Close, which the suite calls itself.
+type Factory func(t *testing.T) cxlocker.RWLocker
+
+// blocked is how long the suite waits before deciding that an acquisition
+// is blocked.
+const blocked = 20 * time.Millisecond
+
+// Run checks the exclusive contract, and that shared holds exclude exclusive
+// ones. Use RunShared as well for lockers whose shared holders admit each
+// other.
+//
+// Each lock is released by the goroutine that acquired it, as pthread-based
+// lockers require.
+func Run(t *testing.T, newLocker Factory) {
+	run(t, newLocker, []test{
+		{"LockUnlock", testLockUnlock},
+		{"TryLockHeld", testTryLockHeld},
+		{"MutualExclusion", testMutualExclusion},
+		{"LockContextTimeout", testLockContextTimeout},
+		{"LockContextDone", testLockContextDone},
+		{"LockContextWaits", testLockContextWaits},
+		{"SharedExcludesExclusive", testSharedExcludesExclusive},
+		{"ExclusiveExcludesShared", testExclusiveExcludesShared},
+	})
+}
+
+// RunShared checks that shared holders admit each other.
+func RunShared(t *testing.T, newLocker Factory) {
+	run(t, newLocker, []test{
+		{"SharedHolders", testSharedHolders},
+	})
+}
+
+type test struct {
+	name string
+	fn   func(t *testing.T, l cxlocker.RWLocker)
+}
+
+func run(t *testing.T, newLocker Factory, tests []test) {
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newLocker(t)
+			tt.fn(t, l)
+			if err := l.Close(); err != nil {
+				t.Errorf("Close: %v", err)
+			}
+		})
+	}
+}
+
+// other runs fn on another goroutine and returns its result.
+func other[T any](fn func() T) T {
+	ch := make(chan T)
+	go func() { ch <- fn() }()
+	return <-ch
+}
+
+// tryAndRelease reports whether try succeeds on another goroutine, which
+// releases the lock again if it does.
+func tryAndRelease(t *testing.T, try func() (bool, error), release func() error) bool {
+	t.Helper()
+	type result struct {
+		ok  bool
+		err error
+	}
+	r := other(func() result {
+		ok, err := try()
+		if ok && err == nil {
+			err = release()
+		}
+		return result{ok, err}
+	})
+	if r.err != nil {
+		t.Fatalf("try: %v", r.err)
+	}
+	return r.ok
+}
+
+func testLockUnlock(t *testing.T, l cxlocker.RWLocker) {
+	for i := 0; i < 3; i++ {
+		if err := l.Lock(); err != nil {
+			t.Fatalf("Lock: %v", err)
+		}
+		if err := l.Unlock(); err != nil {
+			t.Fatalf("Unlock: %v", err)
+		}
+		if err := l.RLock(); err != nil {
+			t.Fatalf("RLock: %v", err)
+		}
+		if err := l.RUnlock(); err != nil {
+			t.Fatalf("RUnlock: %v", err)
+		}
+	}
+}
+
+func testTryLockHeld(t *testing.T, l cxlocker.RWLocker) {
+	if err := l.Lock(); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	if tryAndRelease(t, l.TryLock, l.Unlock) {
+		t.Fatal("TryLock succeeded while the lock was held")
+	}
+	if err := l.Unlock(); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	if !tryAndRelease(t, l.TryLock, l.Unlock) {
+		t.Fatal("TryLock failed on a free lock")
+	}
+}
+
+func testMutualExclusion(t *testing.T, l cxlocker.RWLocker) {
+	const goroutines, iterations = 8, 200
+	var inside, overlaps atomic.Int32
+	var counter int
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines)
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				if err := l.Lock(); err != nil {
+					errs <- err
+					return
+				}
+				if inside.Add(1) != 1 {
+					overlaps.Add(1)
+				}
+				counter++
+				inside.Add(-1)
+				if err := l.Unlock(); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if n := overlaps.Load(); n > 0 {
+		t.Fatalf("%d acquisitions found another holder inside", n)
+	}
+	if counter != goroutines*iterations {
+		t.Fatalf("counter = %d, want %d", counter, goroutines*iterations)
+	}
+}
+
+func testLockContextTimeout(t *testing.T, l cxlocker.RWLocker) {
+	if err := l.Lock(); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	err := other(func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), blocked)
+		defer cancel()
+		err := l.LockContext(ctx)
+		if err == nil {
+			l.Unlock()
+		}
+		return err
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("LockContext on a held lock = %v, want context.DeadlineExceeded", err)
+	}
+	if err := l.Unlock(); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+}
+
+func testLockContextDone(t *testing.T, l cxlocker.RWLocker) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.LockContext(ctx); !errors.Is(err, context.Canceled) {
+		if err == nil {
+			l.Unlock()
+		}
+		t.Fatalf("LockContext with a done context = %v, want context.Canceled", err)
+	}
+	if err := l.RLockContext(ctx); !errors.Is(err, context.Canceled) {
+		if err == nil {
+			l.RUnlock()
+		}
+		t.Fatalf("RLockContext with a done context = %v, want context.Canceled", err)
+	}
+	if !tryAndRelease(t, l.TryLock, l.Unlock) {
+		t.Fatal("a failed LockContext left the lock held")
+	}
+}
+
+func testLockContextWaits(t *testing.T, l cxlocker.RWLocker) {
+	if err := l.Lock(); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	done := make(chan error)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		err := l.LockContext(ctx)
+		if err == nil {
+			err = l.Unlock()
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("LockContext returned %v while the lock was held", err)
+	case <-time.After(blocked):
+	}
+	if err := l.Unlock(); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("LockContext after Unlock: %v", err)
+	}
+}
+
+func testSharedExcludesExclusive(t *testing.T, l cxlocker.RWLocker) {
+	if err := l.RLock(); err != nil {
+		t.Fatalf("RLock: %v", err)
+	}
+	if tryAndRelease(t, l.TryLock, l.Unlock) {
+		t.Fatal("TryLock succeeded while the lock was held shared")
+	}
+	if err := l.RUnlock(); err != nil {
+		t.Fatalf("RUnlock: %v", err)
+	}
+}
+
+func testExclusiveExcludesShared(t *testing.T, l cxlocker.RWLocker) {
+	if err := l.Lock(); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	if tryAndRelease(t, l.TryRLock, l.RUnlock) {
+		t.Fatal("TryRLock succeeded while the lock was held")
+	}
+	err := other(func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), blocked)
+		defer cancel()
+		err := l.RLockContext(ctx)
+		if err == nil {
+			l.RUnlock()
+		}
+		return err
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("RLockContext on a held lock = %v, want context.DeadlineExceeded", err)
+	}
+	if err := l.Unlock(); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+}
+
+func testSharedHolders(t *testing.T, l cxlocker.RWLocker) {
+	if err := l.RLock(); err != nil {
+		t.Fatalf("RLock: %v", err)
+	}
+	if !tryAndRelease(t, l.TryRLock, l.RUnlock) {
+		t.Error("TryRLock failed while the lock was only held shared")
+	}
+	err := other(func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := l.RLockContext(ctx); err != nil {
+			return err
+		}
+		return l.RUnlock()
+	})
+	if err != nil {
+		t.Errorf("RLockContext while held shared: %v", err)
+	}
+	if err := l.RUnlock(); err != nil {
+		t.Fatalf("RUnlock: %v", err)
+	}
+}
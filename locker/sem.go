@@ -0,0 +1,40 @@
+//go:build cgo && (linux || darwin)
+// +build cgo
+// +build linux darwin
+
+package cxlocker
+
+import (
+	"context"
+
+	posixsem "github.com/cloudxaas/golock/sem"
+)
+
+// Semaphore returns a named semaphore as an RWLocker, for locking across
+// processes. The semaphore should have been opened with value 1. It has a
+// single mode, so the shared operations exclude each other like the
+// exclusive ones. Close closes the semaphore but does not unlink it.
+func Semaphore(s *posixsem.Sem) RWLocker {
+	return semLocker{s}
+}
+
+type semLocker struct {
+	s *posixsem.Sem
+}
+
+func (l semLocker) Lock() error            { return l.s.Wait() }
+func (l semLocker) Unlock() error          { return l.s.Post() }
+func (l semLocker) TryLock() (bool, error) { return l.s.TryWait() }
+func (l semLocker) Close() error           { return l.s.Close() }
+
+func (l semLocker) LockContext(ctx context.Context) error {
+	return l.s.WaitContext(ctx)
+}
+
+func (l semLocker) RLock() error            { return l.Lock() }
+func (l semLocker) RUnlock() error          { return l.Unlock() }
+func (l semLocker) TryRLock() (bool, error) { return l.TryLock() }
+
+func (l semLocker) RLockContext(ctx context.Context) error {
+	return l.LockContext(ctx)
+}
@@ -0,0 +1,30 @@
+//go:build cgo && (linux || darwin)
+// +build cgo
+// +build linux darwin
+
+package cxlocker_test
+
+import (
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	cxlocker "github.com/cloudxaas/golock/locker"
+	"github.com/cloudxaas/golock/locker/lockertest"
+	posixsem "github.com/cloudxaas/golock/sem"
+)
+
+var semCount atomic.Int32
+
+func TestSemaphore(t *testing.T) {
+	lockertest.Run(t, func(t *testing.T) cxlocker.RWLocker {
+		name := "/cxlockertest-" + strconv.Itoa(os.Getpid()) + "-" + strconv.Itoa(int(semCount.Add(1)))
+		s, err := posixsem.Open(name, 1)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		t.Cleanup(func() { posixsem.Unlink(name) })
+		return cxlocker.Semaphore(s)
+	})
+}
@@ -0,0 +1,41 @@
+package cxlocker
+
+import (
+	"context"
+
+	cxlockrw "github.com/cloudxaas/golock/rw"
+)
+
+// Shard returns one shard of lock as an RWLocker. Readers of the shard
+// share it. The view's Close does nothing; the ShardedRWLock is closed by
+// its owner.
+func Shard(lock *cxlockrw.ShardedRWLock, shard uint32) RWLocker {
+	return &shardLocker{lock: lock, shard: shard}
+}
+
+// Key returns the shard of key in lock as an RWLocker, like Shard.
+func Key(lock *cxlockrw.ShardedRWLock, key string) RWLocker {
+	return Shard(lock, lock.ShardOf(key))
+}
+
+type shardLocker struct {
+	lock  *cxlockrw.ShardedRWLock
+	shard uint32
+}
+
+func (l *shardLocker) Lock() error            { return l.lock.LockChecked(l.shard) }
+func (l *shardLocker) Unlock() error          { return l.lock.UnlockChecked(l.shard) }
+func (l *shardLocker) TryLock() (bool, error) { return l.lock.TryLock(l.shard) }
+func (l *shardLocker) Close() error           { return nil }
+
+func (l *shardLocker) LockContext(ctx context.Context) error {
+	return l.lock.LockContext(ctx, l.shard)
+}
+
+func (l *shardLocker) RLock() error            { return l.lock.RLockChecked(l.shard) }
+func (l *shardLocker) RUnlock() error          { return l.lock.RUnlockChecked(l.shard) }
+func (l *shardLocker) TryRLock() (bool, error) { return l.lock.TryRLock(l.shard) }
+
+func (l *shardLocker) RLockContext(ctx context.Context) error {
+	return l.lock.RLockContext(ctx, l.shard)
+}
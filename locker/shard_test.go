@@ -0,0 +1,44 @@
+package cxlocker_test
+
+import (
+	"testing"
+
+	cxlocker "github.com/cloudxaas/golock/locker"
+	"github.com/cloudxaas/golock/locker/lockertest"
+	cxlockrw "github.com/cloudxaas/golock/rw"
+)
+
+func TestShard(t *testing.T) {
+	configs := []struct {
+		name string
+		opts []cxlockrw.Option
+	}{
+		{"plain", nil},
+		{"stats", []cxlockrw.Option{cxlockrw.WithStats()}},
+		{"percpu", []cxlockrw.Option{cxlockrw.WithPerCPUReaders()}},
+		{"ownercheck", []cxlockrw.Option{cxlockrw.WithOwnerCheck()}},
+	}
+	for _, b := range []cxlockrw.Backend{cxlockrw.BackendGo, cxlockrw.BackendPthread} {
+		for _, c := range configs {
+			t.Run(b.String()+"/"+c.name, func(t *testing.T) {
+				opts := append([]cxlockrw.Option{cxlockrw.WithBackend(b)}, c.opts...)
+				if lock, err := cxlockrw.New(1, opts...); err != nil {
+					t.Skipf("backend unavailable: %v", err)
+				} else {
+					lock.Close()
+				}
+				newLocker := func(t *testing.T) cxlocker.RWLocker {
+					lock := cxlockrw.NewShardedRWLock(4, opts...)
+					t.Cleanup(func() {
+						if err := lock.Close(); err != nil {
+							t.Errorf("closing the ShardedRWLock: %v", err)
+						}
+					})
+					return cxlocker.Key(lock, "key")
+				}
+				lockertest.Run(t, newLocker)
+				lockertest.RunShared(t, newLocker)
+			})
+		}
+	}
+}
@@ -0,0 +1,241 @@
+// Package cxlockrange provides shared and exclusive locks on ranges of an
+// integer space, such as byte ranges of a file. Locks on ranges that do not
+// overlap never block each other.
+package cxlockrange
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+)
+
+// ErrInvalidRange means a range's start is not before its end.
+var ErrInvalidRange = errors.New("cxlockrange: range start is not before its end")
+
+// RangeLock locks half-open ranges [start, end) of uint64 offsets, where
+// start must be before end. Two locks conflict if their ranges overlap and at
+// least one is exclusive.
+//
+// Waiters are served in arrival order among conflicting requests: a request
+// also waits for earlier waiters it conflicts with, so a stream of shared
+// locks cannot starve an exclusive one. Holders and waiters are kept in
+// lists, so each operation is linear in their number.
+//
+// The zero value is an unlocked RangeLock.
+type RangeLock struct {
+	mu      sync.Mutex
+	held    list
+	waiting list
+}
+
+// Held is a lock on a range, returned by the RangeLock methods. Release it
+// with Unlock.
+type Held struct {
+	rl         *RangeLock
+	start, end uint64
+	write      bool
+	ready      chan struct{}
+	queued     bool // in waiting rather than held
+	unlocked   bool // Unlock has run; guarded by rl.mu
+	prev, next *Held
+}
+
+// list is a doubly linked list of Held.
+type list struct {
+	head, tail *Held
+}
+
+func (l *list) push(h *Held) {
+	h.prev, h.next = l.tail, nil
+	if l.tail != nil {
+		l.tail.next = h
+	} else {
+		l.head = h
+	}
+	l.tail = h
+}
+
+func (l *list) remove(h *Held) {
+	if h.prev != nil {
+		h.prev.next = h.next
+	} else {
+		l.head = h.next
+	}
+	if h.next != nil {
+		h.next.prev = h.prev
+	} else {
+		l.tail = h.prev
+	}
+	h.prev, h.next = nil, nil
+}
+
+// conflicts reports whether h and o cannot be held at the same time.
+func (h *Held) conflicts(o *Held) bool {
+	return (h.write || o.write) && h.start < o.end && o.start < h.end
+}
+
+// conflictsWith reports whether h conflicts with an entry of l before stop.
+func (h *Held) conflictsWith(l *list, stop *Held) bool {
+	for o := l.head; o != stop; o = o.next {
+		if h.conflicts(o) {
+			return true
+		}
+	}
+	return false
+}
+
+// Start returns the first offset of the range.
+func (h *Held) Start() uint64 { return h.start }
+
+// End returns the offset just past the range.
+func (h *Held) End() uint64 { return h.end }
+
+// Exclusive reports whether the range is locked exclusively.
+func (h *Held) Exclusive() bool { return h.write }
+
+// String returns the range and mode, such as "[0, 4096) exclusive".
+func (h *Held) String() string {
+	mode := "shared"
+	if h.write {
+		mode = "exclusive"
+	}
+	return "[" + strconv.FormatUint(h.start, 10) + ", " + strconv.FormatUint(h.end, 10) + ") " + mode
+}
+
+// Lock locks [start, end) exclusively, waiting for conflicting holders.
+// It panics with ErrInvalidRange if start >= end.
+func (rl *RangeLock) Lock(start, end uint64) *Held {
+	h, err := rl.LockContext(context.Background(), start, end)
+	if err != nil {
+		panic(err)
+	}
+	return h
+}
+
+// RLock locks [start, end) shared, waiting for conflicting holders.
+// It panics with ErrInvalidRange if start >= end.
+func (rl *RangeLock) RLock(start, end uint64) *Held {
+	h, err := rl.RLockContext(context.Background(), start, end)
+	if err != nil {
+		panic(err)
+	}
+	return h
+}
+
+// LockContext locks [start, end) exclusively, waiting until ctx is done.
+// It returns ctx.Err() if the range could not be locked in time, and
+// ErrInvalidRange if start >= end.
+func (rl *RangeLock) LockContext(ctx context.Context, start, end uint64) (*Held, error) {
+	return rl.acquire(ctx, start, end, true)
+}
+
+// RLockContext locks [start, end) shared, waiting until ctx is done.
+// It returns ctx.Err() if the range could not be locked in time, and
+// ErrInvalidRange if start >= end.
+func (rl *RangeLock) RLockContext(ctx context.Context, start, end uint64) (*Held, error) {
+	return rl.acquire(ctx, start, end, false)
+}
+
+// TryLock locks [start, end) exclusively if that is possible without waiting.
+// It returns false with a nil error if the range is busy, and
+// ErrInvalidRange if start >= end.
+func (rl *RangeLock) TryLock(start, end uint64) (*Held, bool, error) {
+	return rl.try(start, end, true)
+}
+
+// TryRLock locks [start, end) shared if that is possible without waiting.
+// It returns false with a nil error if the range is busy, and
+// ErrInvalidRange if start >= end.
+func (rl *RangeLock) TryRLock(start, end uint64) (*Held, bool, error) {
+	return rl.try(start, end, false)
+}
+
+// admissible reports whether h can be held now. Waiters before stop take
+// precedence.
+func (rl *RangeLock) admissible(h *Held, stop *Held) bool {
+	return !h.conflictsWith(&rl.held, nil) && !h.conflictsWith(&rl.waiting, stop)
+}
+
+func (rl *RangeLock) try(start, end uint64, write bool) (*Held, bool, error) {
+	if start >= end {
+		return nil, false, ErrInvalidRange
+	}
+	h := &Held{rl: rl, start: start, end: end, write: write}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if !rl.admissible(h, nil) {
+		return nil, false, nil
+	}
+	rl.held.push(h)
+	return h, true, nil
+}
+
+func (rl *RangeLock) acquire(ctx context.Context, start, end uint64, write bool) (*Held, error) {
+	if start >= end {
+		return nil, ErrInvalidRange
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	h := &Held{rl: rl, start: start, end: end, write: write}
+	rl.mu.Lock()
+	if rl.admissible(h, nil) {
+		rl.held.push(h)
+		rl.mu.Unlock()
+		return h, nil
+	}
+	h.ready = make(chan struct{}, 1)
+	h.queued = true
+	rl.waiting.push(h)
+	rl.mu.Unlock()
+
+	select {
+	case <-h.ready:
+		return h, nil
+	case <-ctx.Done():
+	}
+	rl.mu.Lock()
+	if h.queued {
+		// Leaving the queue may unblock the waiters behind h.
+		rl.waiting.remove(h)
+		h.queued = false
+		rl.grant()
+		rl.mu.Unlock()
+		return nil, ctx.Err()
+	}
+	rl.mu.Unlock()
+	// The range was granted while giving up; give it back.
+	<-h.ready
+	h.Unlock()
+	return nil, ctx.Err()
+}
+
+// grant moves every waiter that can now be held from waiting to held.
+// rl.mu must be held.
+func (rl *RangeLock) grant() {
+	for h := rl.waiting.head; h != nil; {
+		next := h.next
+		if rl.admissible(h, h) {
+			rl.waiting.remove(h)
+			h.queued = false
+			rl.held.push(h)
+			h.ready <- struct{}{}
+		}
+		h = next
+	}
+}
+
+// Unlock releases the range. Calling Unlock again, even concurrently, does
+// nothing.
+func (h *Held) Unlock() {
+	rl := h.rl
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if h.unlocked {
+		return
+	}
+	h.unlocked = true
+	rl.held.remove(h)
+	rl.grant()
+}
@@ -0,0 +1,190 @@
+package cxlockrange
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blocked is how long the tests wait before deciding that a lock is blocked.
+const blocked = 20 * time.Millisecond
+
+// tryLock tries [start, end) in the given mode and releases it again,
+// reporting whether it was free.
+func tryLock(t *testing.T, rl *RangeLock, start, end uint64, write bool) bool {
+	t.Helper()
+	try := rl.TryRLock
+	if write {
+		try = rl.TryLock
+	}
+	h, ok, err := try(start, end)
+	if err != nil {
+		t.Fatalf("try [%d, %d): %v", start, end, err)
+	}
+	if ok {
+		h.Unlock()
+	}
+	return ok
+}
+
+func TestOverlap(t *testing.T) {
+	tests := []struct {
+		name       string
+		start, end uint64
+		free       bool
+	}{
+		{"same", 10, 20, false},
+		{"inside", 12, 18, false},
+		{"around", 0, 30, false},
+		{"head", 5, 11, false},
+		{"tail", 19, 25, false},
+		{"before", 0, 10, true},
+		{"after", 20, 30, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rl RangeLock
+			h := rl.Lock(10, 20)
+			if got := tryLock(t, &rl, tt.start, tt.end, true); got != tt.free {
+				t.Errorf("TryLock(%d, %d) beside exclusive [10, 20) = %v, want %v", tt.start, tt.end, got, tt.free)
+			}
+			if got := tryLock(t, &rl, tt.start, tt.end, false); got != tt.free {
+				t.Errorf("TryRLock(%d, %d) beside exclusive [10, 20) = %v, want %v", tt.start, tt.end, got, tt.free)
+			}
+			h.Unlock()
+
+			h = rl.RLock(10, 20)
+			if got := tryLock(t, &rl, tt.start, tt.end, true); got != tt.free {
+				t.Errorf("TryLock(%d, %d) beside shared [10, 20) = %v, want %v", tt.start, tt.end, got, tt.free)
+			}
+			if !tryLock(t, &rl, tt.start, tt.end, false) {
+				t.Errorf("TryRLock(%d, %d) beside shared [10, 20) failed", tt.start, tt.end)
+			}
+			h.Unlock()
+		})
+	}
+}
+
+func TestInvalidRange(t *testing.T) {
+	var rl RangeLock
+	for _, r := range [][2]uint64{{5, 5}, {6, 5}} {
+		if _, err := rl.LockContext(context.Background(), r[0], r[1]); !errors.Is(err, ErrInvalidRange) {
+			t.Errorf("LockContext(%d, %d) = %v, want ErrInvalidRange", r[0], r[1], err)
+		}
+		if _, err := rl.RLockContext(context.Background(), r[0], r[1]); !errors.Is(err, ErrInvalidRange) {
+			t.Errorf("RLockContext(%d, %d) = %v, want ErrInvalidRange", r[0], r[1], err)
+		}
+		if _, ok, err := rl.TryLock(r[0], r[1]); ok || !errors.Is(err, ErrInvalidRange) {
+			t.Errorf("TryLock(%d, %d) = %v, %v, want false, ErrInvalidRange", r[0], r[1], ok, err)
+		}
+		if _, ok, err := rl.TryRLock(r[0], r[1]); ok || !errors.Is(err, ErrInvalidRange) {
+			t.Errorf("TryRLock(%d, %d) = %v, %v, want false, ErrInvalidRange", r[0], r[1], ok, err)
+		}
+		func() {
+			defer func() {
+				if err, _ := recover().(error); !errors.Is(err, ErrInvalidRange) {
+					t.Errorf("Lock(%d, %d) panicked with %v, want ErrInvalidRange", r[0], r[1], err)
+				}
+			}()
+			rl.Lock(r[0], r[1])
+		}()
+	}
+}
+
+// A waiter that times out must leave the queue, so it blocks no one later.
+func TestLockContextTimeout(t *testing.T) {
+	var rl RangeLock
+	h := rl.RLock(0, 10)
+	ctx, cancel := context.WithTimeout(context.Background(), blocked)
+	defer cancel()
+	if _, err := rl.LockContext(ctx, 5, 15); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("LockContext on a shared range = %v, want context.DeadlineExceeded", err)
+	}
+	rl.mu.Lock()
+	stale := rl.waiting.head != nil
+	rl.mu.Unlock()
+	if stale {
+		t.Fatal("the timed-out waiter is still queued")
+	}
+	// A queued exclusive waiter would hold this back.
+	if !tryLock(t, &rl, 0, 10, false) {
+		t.Error("TryRLock after the timeout failed")
+	}
+	h.Unlock()
+	if !tryLock(t, &rl, 0, 15, true) {
+		t.Error("TryLock after the holder left failed")
+	}
+}
+
+// Conflicting waiters are granted in arrival order.
+func TestFIFO(t *testing.T) {
+	var rl RangeLock
+	h := rl.Lock(0, 100)
+	var (
+		mu    sync.Mutex
+		order []int
+		wg    sync.WaitGroup
+	)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := rl.Lock(0, 100)
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			w.Unlock()
+		}()
+		// Let it queue before the next one arrives.
+		time.Sleep(blocked)
+	}
+	h.Unlock()
+	wg.Wait()
+	for i, got := range order {
+		if got != i {
+			t.Fatalf("waiters were granted in order %v, want ascending", order)
+		}
+	}
+}
+
+// A shared request arriving after a conflicting exclusive waiter queues
+// behind it, while one that does not conflict with the waiter goes ahead.
+func TestWaiterPrecedence(t *testing.T) {
+	var rl RangeLock
+	h := rl.RLock(0, 10)
+	writer := make(chan *Held)
+	go func() { writer <- rl.Lock(5, 15) }()
+	time.Sleep(blocked)
+	if tryLock(t, &rl, 8, 12, false) {
+		t.Error("TryRLock overlapping a waiting writer succeeded")
+	}
+	if !tryLock(t, &rl, 0, 5, false) {
+		t.Error("TryRLock beside a waiting writer failed")
+	}
+	h.Unlock()
+	(<-writer).Unlock()
+}
+
+// Concurrent Unlocks of the same Held release it once.
+func TestUnlockTwice(t *testing.T) {
+	var rl RangeLock
+	for i := 0; i < 100; i++ {
+		h := rl.Lock(0, 10)
+		other := rl.RLock(20, 30)
+		var wg sync.WaitGroup
+		for j := 0; j < 2; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				h.Unlock()
+			}()
+		}
+		wg.Wait()
+		if tryLock(t, &rl, 20, 30, true) {
+			t.Fatal("a second Unlock released another holder")
+		}
+		other.Unlock()
+	}
+}
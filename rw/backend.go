@@ -0,0 +1,93 @@
+package cxlockrw
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// Backend selects the lock implementation behind a ShardedRWLock.
+type Backend int
+
+const (
+	// BackendAuto uses BackendPthread when cgo is available and BackendGo otherwise.
+	BackendAuto Backend = iota
+	// BackendPthread stores each shard in a POSIX pthread_rwlock_t. It requires cgo.
+	BackendPthread
+	// BackendGo stores each shard in a read-write lock implemented in pure Go.
+	BackendGo
+)
+
+// String returns the backend name.
+func (b Backend) String() string {
+	switch b {
+	case BackendAuto:
+		return "auto"
+	case BackendPthread:
+		return "pthread"
+	case BackendGo:
+		return "go"
+	}
+	return "unknown"
+}
+
+// backend is the per-shard lock storage used by ShardedRWLock.
+// Shard indices are always in range. Failures are reported as syscall.Errno
+// values, which ShardedRWLock wraps in a *LockError. seq returns the shard's
+// write sequence, which lives next to the lock so that it is shared wherever
+// the lock is. newCond returns a condition variable for the shard's write
+// lock.
+type backend interface {
+	rlock(shard uint32) error
+	runlock(shard uint32) error
+	lock(shard uint32) error
+	unlock(shard uint32) error
+	tryRLock(shard uint32) (bool, error)
+	tryLock(shard uint32) (bool, error)
+	rlockContext(ctx context.Context, shard uint32) error
+	lockContext(ctx context.Context, shard uint32) error
+	ulock(shard uint32) error
+	uunlock(shard uint32) error
+	upgrade(shard uint32) error
+	downgrade(shard uint32) error
+	seq(shard uint32) *atomic.Uint64
+	newCond(shard uint32) (shardCond, error)
+	close() error
+}
+
+// resolve turns BackendAuto into the concrete backend for this build.
+func (b Backend) resolve() Backend {
+	if b == BackendAuto {
+		if pthreadAvailable {
+			return BackendPthread
+		}
+		return BackendGo
+	}
+	return b
+}
+
+// newBackend creates the storage for kind, which must already be resolved.
+func newBackend(kind Backend, numShards int, cfg *config) (backend, error) {
+	var b backend
+	switch kind {
+	case BackendPthread:
+		if !pthreadAvailable {
+			return nil, errors.New("cxlockrw: pthread backend requires cgo")
+		}
+		if cfg.policy == PolicyFIFO {
+			return nil, errors.New("cxlockrw: PolicyFIFO requires BackendGo")
+		}
+		var err error
+		if b, err = newPthreadBackend(numShards, cfg.policy, cfg.cacheLine); err != nil {
+			return nil, err
+		}
+	case BackendGo:
+		b = newGoBackend(numShards, cfg.policy, cfg.cacheLine)
+	default:
+		return nil, errors.New("cxlockrw: unknown backend " + kind.String())
+	}
+	if cfg.bigReader {
+		b = newBigReaderBackend(b, numShards, cfg.cacheLine)
+	}
+	return b, nil
+}
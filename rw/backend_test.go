@@ -0,0 +1,368 @@
+package cxlockrw
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// backends returns the backends compiled into this build.
+func backends() []Backend {
+	if pthreadAvailable {
+		return []Backend{BackendGo, BackendPthread}
+	}
+	return []Backend{BackendGo}
+}
+
+// eachBackend runs fn as a subtest for every backend in this build.
+func eachBackend(t *testing.T, fn func(t *testing.T, b Backend)) {
+	for _, b := range backends() {
+		t.Run(b.String(), func(t *testing.T) { fn(t, b) })
+	}
+}
+
+// newTestLock returns a lock with the backend b that is closed when the test
+// ends.
+func newTestLock(t testing.TB, b Backend, numShards int, opts ...Option) *ShardedRWLock {
+	t.Helper()
+	lock := NewShardedRWLock(numShards, append([]Option{WithBackend(b)}, opts...)...)
+	t.Cleanup(func() { lock.Close() })
+	return lock
+}
+
+// blocked is how long the tests wait before deciding that an acquisition is
+// blocked.
+const blocked = 20 * time.Millisecond
+
+// acquireAsync starts a goroutine that locks shard and releases it again once
+// release is closed. The returned channel is closed once the shard is held.
+func acquireAsync(lock *ShardedRWLock, shard uint32, write bool, release <-chan struct{}) <-chan struct{} {
+	held := make(chan struct{})
+	go func() {
+		// pthread write locks belong to the thread that took them.
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		if write {
+			lock.Lock(shard)
+			close(held)
+			<-release
+			lock.Unlock(shard)
+			return
+		}
+		lock.RLock(shard)
+		close(held)
+		<-release
+		lock.RUnlock(shard)
+	}()
+	return held
+}
+
+// acquiredWithin reports whether held is closed within blocked.
+func acquiredWithin(held <-chan struct{}) bool {
+	select {
+	case <-held:
+		return true
+	case <-time.After(blocked):
+		return false
+	}
+}
+
+// waitingWriter starts a goroutine that write-locks shard and releases it
+// again, and returns once the goroutine has had time to queue. The returned
+// channel yields the goroutine's error once it is done.
+func waitingWriter(lock *ShardedRWLock, shard uint32) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		err := lock.LockChecked(shard)
+		if err == nil {
+			err = lock.UnlockChecked(shard)
+		}
+		done <- err
+	}()
+	time.Sleep(blocked)
+	return done
+}
+
+// The backends must behave the same. Every case runs against each of them,
+// on a goroutine wired to its thread, as pthread write locks require.
+func TestBackendSemantics(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		fn   func(t *testing.T, lock *ShardedRWLock)
+	}{
+		{
+			name: "ReadersShare",
+			fn: func(t *testing.T, lock *ShardedRWLock) {
+				lock.RLock(0)
+				release := make(chan struct{})
+				if !acquiredWithin(acquireAsync(lock, 0, false, release)) {
+					t.Error("RLock blocked beside another reader")
+				}
+				close(release)
+				lock.RUnlock(0)
+			},
+		},
+		{
+			name: "WriterExcludes",
+			fn: func(t *testing.T, lock *ShardedRWLock) {
+				lock.Lock(0)
+				release := make(chan struct{})
+				reader := acquireAsync(lock, 0, false, release)
+				writer := acquireAsync(lock, 0, true, release)
+				if acquiredWithin(reader) {
+					t.Error("RLock succeeded while the shard was write-locked")
+				}
+				if acquiredWithin(writer) {
+					t.Error("Lock succeeded while the shard was write-locked")
+				}
+				lock.Unlock(0)
+				close(release)
+				<-reader
+				<-writer
+			},
+		},
+		{
+			name: "OtherShardsFree",
+			fn: func(t *testing.T, lock *ShardedRWLock) {
+				lock.Lock(0)
+				release := make(chan struct{})
+				if !acquiredWithin(acquireAsync(lock, 1, true, release)) {
+					t.Error("Lock of shard 1 blocked on shard 0")
+				}
+				close(release)
+				lock.Unlock(0)
+			},
+		},
+		{
+			name: "ReaderPreferred",
+			fn: func(t *testing.T, lock *ShardedRWLock) {
+				lock.RLock(0)
+				release := make(chan struct{})
+				writer := acquireAsync(lock, 0, true, release)
+				if acquiredWithin(writer) {
+					t.Fatal("Lock succeeded while the shard was read-locked")
+				}
+				// The writer is queued now; a new reader still gets in.
+				readerRelease := make(chan struct{})
+				if !acquiredWithin(acquireAsync(lock, 0, false, readerRelease)) {
+					t.Error("RLock blocked behind a waiting writer")
+				}
+				close(readerRelease)
+				lock.RUnlock(0)
+				close(release)
+				<-writer
+			},
+		},
+		{
+			name: "TryLockWriteHeld",
+			fn: func(t *testing.T, lock *ShardedRWLock) {
+				lock.Lock(0)
+				defer lock.Unlock(0)
+				if ok, err := tryOther(lock, true); ok || err != nil {
+					t.Errorf("TryLock = %v, %v, want false, nil", ok, err)
+				}
+				if ok, err := tryOther(lock, false); ok || err != nil {
+					t.Errorf("TryRLock = %v, %v, want false, nil", ok, err)
+				}
+			},
+		},
+		{
+			name: "TryLockReadHeld",
+			fn: func(t *testing.T, lock *ShardedRWLock) {
+				lock.RLock(0)
+				defer lock.RUnlock(0)
+				if ok, err := tryOther(lock, true); ok || err != nil {
+					t.Errorf("TryLock = %v, %v, want false, nil", ok, err)
+				}
+				if ok, err := tryOther(lock, false); !ok || err != nil {
+					t.Errorf("TryRLock = %v, %v, want true, nil", ok, err)
+				}
+			},
+		},
+		{
+			name: "WriterPreferred",
+			opts: []Option{WithPolicy(PolicyWriterPreferred)},
+			fn: func(t *testing.T, lock *ShardedRWLock) {
+				lock.RLock(0)
+				writer := waitingWriter(lock, 0)
+				if ok, err := tryOther(lock, false); ok || err != nil {
+					t.Errorf("TryRLock with a waiting writer = %v, %v, want false, nil", ok, err)
+				}
+				lock.RUnlock(0)
+				if err := <-writer; err != nil {
+					t.Fatalf("writer: %v", err)
+				}
+			},
+		},
+		{
+			name: "UnlockNotHeld",
+			fn: func(t *testing.T, lock *ShardedRWLock) {
+				if err := lock.UnlockChecked(0); !errors.Is(err, ErrNotHeld) {
+					t.Errorf("UnlockChecked = %v, want ErrNotHeld", err)
+				}
+				if err := lock.UpgradableRUnlockChecked(0); !errors.Is(err, ErrNotHeld) {
+					t.Errorf("UpgradableRUnlockChecked = %v, want ErrNotHeld", err)
+				}
+				if ok, err := lock.TryLock(0); !ok || err != nil {
+					t.Fatalf("TryLock after the failed unlocks = %v, %v, want true, nil", ok, err)
+				}
+				lock.Unlock(0)
+			},
+		},
+		{
+			name: "RecursiveLockOwnerCheck",
+			opts: []Option{WithOwnerCheck()},
+			fn: func(t *testing.T, lock *ShardedRWLock) {
+				lock.Lock(0)
+				if err := lock.LockChecked(0); !errors.Is(err, ErrDeadlock) {
+					t.Errorf("LockChecked after Lock = %v, want ErrDeadlock", err)
+				}
+				if err := lock.LockContext(context.Background(), 0); !errors.Is(err, ErrDeadlock) {
+					t.Errorf("LockContext after Lock = %v, want ErrDeadlock", err)
+				}
+				if err := lock.RLockChecked(0); !errors.Is(err, ErrDeadlock) {
+					t.Errorf("RLockChecked after Lock = %v, want ErrDeadlock", err)
+				}
+				lock.Unlock(0)
+				lock.RLock(0)
+				if err := lock.LockChecked(0); !errors.Is(err, ErrDeadlock) {
+					t.Errorf("LockChecked after RLock = %v, want ErrDeadlock", err)
+				}
+				lock.RUnlock(0)
+				lock.UpgradableRLock(0)
+				if err := lock.UpgradableRLockChecked(0); !errors.Is(err, ErrDeadlock) {
+					t.Errorf("UpgradableRLockChecked after UpgradableRLock = %v, want ErrDeadlock", err)
+				}
+				lock.UpgradableRUnlock(0)
+				if ok, err := lock.TryLock(0); !ok || err != nil {
+					t.Fatalf("TryLock after the refused acquisitions = %v, %v, want true, nil", ok, err)
+				}
+				lock.Unlock(0)
+			},
+		},
+		{
+			name: "LockContextTimeout",
+			fn: func(t *testing.T, lock *ShardedRWLock) {
+				lock.Lock(0)
+				err := other(func() error {
+					err := lock.LockTimeout(0, blocked)
+					if err == nil {
+						lock.Unlock(0)
+					}
+					return err
+				})
+				if !errors.Is(err, context.DeadlineExceeded) {
+					t.Errorf("LockTimeout on a held shard = %v, want context.DeadlineExceeded", err)
+				}
+				err = other(func() error {
+					err := lock.RLockTimeout(0, blocked)
+					if err == nil {
+						lock.RUnlock(0)
+					}
+					return err
+				})
+				if !errors.Is(err, context.DeadlineExceeded) {
+					t.Errorf("RLockTimeout on a held shard = %v, want context.DeadlineExceeded", err)
+				}
+				lock.Unlock(0)
+				// The timed-out acquisitions must not have left anything behind.
+				if ok, err := lock.TryLock(0); !ok || err != nil {
+					t.Fatalf("TryLock after the timeouts = %v, %v, want true, nil", ok, err)
+				}
+				lock.Unlock(0)
+				if err := lock.Close(); err != nil {
+					t.Fatalf("Close after the timeouts: %v", err)
+				}
+			},
+		},
+		{
+			name: "LockContextCancel",
+			fn: func(t *testing.T, lock *ShardedRWLock) {
+				lock.Lock(0)
+				ctx, cancel := context.WithCancel(context.Background())
+				done := make(chan error, 1)
+				go func() {
+					err := lock.LockContext(ctx, 0)
+					if err == nil {
+						lock.Unlock(0)
+					}
+					done <- err
+				}()
+				time.Sleep(blocked)
+				cancel()
+				if err := <-done; !errors.Is(err, context.Canceled) {
+					t.Errorf("LockContext cancelled while waiting = %v, want context.Canceled", err)
+				}
+				lock.Unlock(0)
+				if ok, err := lock.TryLock(0); !ok || err != nil {
+					t.Fatalf("TryLock after the cancellation = %v, %v, want true, nil", ok, err)
+				}
+				lock.Unlock(0)
+			},
+		},
+		{
+			name: "UpgradeDowngrade",
+			fn: func(t *testing.T, lock *ShardedRWLock) {
+				lock.UpgradableRLock(0)
+				if ok, err := tryOther(lock, false); !ok || err != nil {
+					t.Errorf("TryRLock beside an upgradable reader = %v, %v, want true, nil", ok, err)
+				}
+				lock.Upgrade(0)
+				if ok, err := tryOther(lock, false); ok || err != nil {
+					t.Errorf("TryRLock after Upgrade = %v, %v, want false, nil", ok, err)
+				}
+				lock.Downgrade(0)
+				if ok, err := tryOther(lock, false); !ok || err != nil {
+					t.Errorf("TryRLock after Downgrade = %v, %v, want true, nil", ok, err)
+				}
+				lock.RUnlock(0)
+				if ok, err := lock.TryLock(0); !ok || err != nil {
+					t.Fatalf("TryLock after RUnlock = %v, %v, want true, nil", ok, err)
+				}
+				lock.Unlock(0)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eachBackend(t, func(t *testing.T, b Backend) {
+				runtime.LockOSThread()
+				defer runtime.UnlockOSThread()
+				tt.fn(t, newTestLock(t, b, 2, tt.opts...))
+			})
+		})
+	}
+}
+
+// other runs fn on another goroutine and returns its result, so that a
+// pthread write lock held by the caller's thread is seen as foreign.
+func other[T any](fn func() T) T {
+	ch := make(chan T)
+	go func() { ch <- fn() }()
+	return <-ch
+}
+
+// tryOther tries shard 0 on another goroutine, which releases it again if
+// that worked.
+func tryOther(lock *ShardedRWLock, write bool) (bool, error) {
+	type result struct {
+		ok  bool
+		err error
+	}
+	r := other(func() result {
+		var ok bool
+		var err error
+		if write {
+			if ok, err = lock.TryLock(0); ok {
+				lock.Unlock(0)
+			}
+		} else if ok, err = lock.TryRLock(0); ok {
+			lock.RUnlock(0)
+		}
+		return result{ok, err}
+	})
+	return r.ok, r.err
+}
@@ -0,0 +1,228 @@
+package cxlockrw
+
+import (
+	"context"
+	"math/bits"
+	"runtime"
+	"sync/atomic"
+	"time"
+	_ "unsafe" // for go:linkname
+)
+
+// bigReaderBackend gives every shard a set of reader slots, one cache line
+// each, in the style of the kernel's big-reader locks. A reader increments
+// the slot of the P it runs on and only looks at the shard's lock if a
+// writer is active, so readers of one hot shard on different Ps update
+// different lines instead of all updating the same reader count.
+//
+// A writer takes the write lock of the underlying backend, which excludes
+// other writers and upgradable readers, raises the shard's writing flag so
+// that new readers back off, and waits for the slots to sum to zero. A read
+// unlock decrements the slot of its own P, which is the slot its lock
+// incremented unless the goroutine moved to another P in between; as with
+// the kernel's percpu-rwsem only the sum matters, so a slot may go negative.
+type bigReaderBackend struct {
+	backend
+	perShard int // slots per shard, a power of two
+	slots    []readerSlot
+	slotStep int
+	flags    []writerFlag
+	flagStep int
+}
+
+// readerSlot is a reader count on its own cache line.
+type readerSlot struct {
+	n atomic.Int64
+	_ [minCacheLine - 8]byte
+}
+
+// writerFlag is set while a writer holds or is draining a shard.
+type writerFlag struct {
+	writing atomic.Bool
+	_       [minCacheLine - 4]byte
+}
+
+// maxReaderSlots caps the slots per shard.
+const maxReaderSlots = 64
+
+// newBigReaderBackend wraps b with reader slots sized to GOMAXPROCS.
+func newBigReaderBackend(b backend, numShards, line int) *bigReaderBackend {
+	perShard := 1 << bits.Len(uint(runtime.GOMAXPROCS(0)-1))
+	if perShard > maxReaderSlots {
+		perShard = maxReaderSlots
+	}
+	br := &bigReaderBackend{backend: b, perShard: perShard}
+	br.slots, br.slotStep = alignedSlice[readerSlot](numShards*perShard, line)
+	br.flags, br.flagStep = alignedSlice[writerFlag](numShards, line)
+	return br
+}
+
+// slot returns reader slot i of shard.
+func (b *bigReaderBackend) slot(shard uint32, i int) *atomic.Int64 {
+	return &b.slots[(int(shard)*b.perShard+i)*b.slotStep].n
+}
+
+// localSlot returns the reader slot of shard for the P the caller runs on.
+// Ps beyond the slot count, after GOMAXPROCS grew, share slots.
+func (b *bigReaderBackend) localSlot(shard uint32) *atomic.Int64 {
+	p := procPin()
+	procUnpin()
+	return b.slot(shard, p&(b.perShard-1))
+}
+
+// procPin pins the goroutine to its P and returns the P's id; procUnpin
+// undoes it. sync.Pool finds its per-P pools the same way.
+//
+// No exported API reports the current P, and a reader must find the slot of
+// the P it runs on to keep its cache line to itself. Since Go 1.23 the linker
+// rejects pull-only linknames into the runtime, but runtime/proc.go keeps
+// procPin and procUnpin as push linknames because widely used packages call
+// them, so this builds without -checklinkname=0. Checked with Go 1.23 to 1.27.
+//
+//go:linkname procPin runtime.procPin
+func procPin() int
+
+//go:linkname procUnpin runtime.procUnpin
+func procUnpin()
+
+// writing returns the writing flag of shard.
+func (b *bigReaderBackend) writing(shard uint32) *atomic.Bool {
+	return &b.flags[int(shard)*b.flagStep].writing
+}
+
+// readers returns the number of readers registered in shard's slots.
+func (b *bigReaderBackend) readers(shard uint32) int64 {
+	var n int64
+	for i := 0; i < b.perShard; i++ {
+		n += b.slot(shard, i).Load()
+	}
+	return n
+}
+
+// enterRead registers a reader unless a writer is active.
+func (b *bigReaderBackend) enterRead(shard uint32) bool {
+	slot := b.localSlot(shard)
+	slot.Add(1)
+	if !b.writing(shard).Load() {
+		return true
+	}
+	slot.Add(-1)
+	return false
+}
+
+// drain waits for the readers registered before the writing flag was raised
+// to leave. Writers are rare in this mode, so it polls.
+func (b *bigReaderBackend) drain(ctx context.Context, shard uint32) error {
+	for spins := 0; b.readers(shard) != 0; spins++ {
+		if spins < 64 {
+			runtime.Gosched()
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		time.Sleep(10 * time.Microsecond)
+	}
+	return nil
+}
+
+// acquired finishes a write acquisition of the underlying lock by draining
+// the readers, giving the lock back if ctx is done first.
+func (b *bigReaderBackend) acquired(ctx context.Context, shard uint32) error {
+	b.writing(shard).Store(true)
+	if err := b.drain(ctx, shard); err != nil {
+		b.writing(shard).Store(false)
+		b.backend.unlock(shard)
+		return err
+	}
+	return nil
+}
+
+func (b *bigReaderBackend) rlock(shard uint32) error {
+	for !b.enterRead(shard) {
+		// Wait for the writer by passing through the underlying lock.
+		if err := b.backend.rlock(shard); err != nil {
+			return err
+		}
+		if err := b.backend.runlock(shard); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *bigReaderBackend) rlockContext(ctx context.Context, shard uint32) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for !b.enterRead(shard) {
+		if err := b.backend.rlockContext(ctx, shard); err != nil {
+			return err
+		}
+		if err := b.backend.runlock(shard); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *bigReaderBackend) tryRLock(shard uint32) (bool, error) {
+	return b.enterRead(shard), nil
+}
+
+func (b *bigReaderBackend) runlock(shard uint32) error {
+	b.localSlot(shard).Add(-1)
+	return nil
+}
+
+func (b *bigReaderBackend) lock(shard uint32) error {
+	if err := b.backend.lock(shard); err != nil {
+		return err
+	}
+	return b.acquired(context.Background(), shard)
+}
+
+func (b *bigReaderBackend) lockContext(ctx context.Context, shard uint32) error {
+	if err := b.backend.lockContext(ctx, shard); err != nil {
+		return err
+	}
+	return b.acquired(ctx, shard)
+}
+
+func (b *bigReaderBackend) tryLock(shard uint32) (bool, error) {
+	if ok, err := b.backend.tryLock(shard); !ok {
+		return false, err
+	}
+	b.writing(shard).Store(true)
+	if b.readers(shard) != 0 {
+		b.writing(shard).Store(false)
+		return false, b.backend.unlock(shard)
+	}
+	return true, nil
+}
+
+func (b *bigReaderBackend) unlock(shard uint32) error {
+	b.writing(shard).Store(false)
+	return b.backend.unlock(shard)
+}
+
+func (b *bigReaderBackend) upgrade(shard uint32) error {
+	if err := b.backend.upgrade(shard); err != nil {
+		return err
+	}
+	return b.acquired(context.Background(), shard)
+}
+
+// newCond waits through the slot-aware write lock rather than the underlying
+// backend's native condition variable.
+func (b *bigReaderBackend) newCond(shard uint32) (shardCond, error) {
+	return newGoCond(b, shard), nil
+}
+
+// downgrade registers the caller as a slot reader before letting writers
+// back in, so that the RUnlock that follows finds it there.
+func (b *bigReaderBackend) downgrade(shard uint32) error {
+	b.localSlot(shard).Add(1)
+	b.writing(shard).Store(false)
+	return b.backend.unlock(shard)
+}
@@ -0,0 +1,59 @@
+package cxlockrw
+
+import (
+	"testing"
+	"time"
+)
+
+// Only the sum of a shard's slots matters, so a read lock may be released
+// from another goroutine, and so from another P's slot.
+func TestPerCPUReadersUnlockElsewhere(t *testing.T) {
+	eachBackend(t, func(t *testing.T, b Backend) {
+		lock := newTestLock(t, b, 1, WithPerCPUReaders())
+		const readers = 16
+		for i := 0; i < readers; i++ {
+			lock.RLock(0)
+		}
+		if ok, _ := lock.TryLock(0); ok {
+			t.Fatal("TryLock succeeded with readers registered")
+		}
+		done := make(chan struct{})
+		for i := 0; i < readers; i++ {
+			go func() {
+				lock.RUnlock(0)
+				done <- struct{}{}
+			}()
+		}
+		for i := 0; i < readers; i++ {
+			<-done
+		}
+		if err := lock.LockTimeout(0, time.Second); err != nil {
+			t.Fatalf("LockTimeout after the readers left: %v", err)
+		}
+		lock.Unlock(0)
+	})
+}
+
+// BenchmarkHotShardReads read-locks a single shard from parallel goroutines,
+// with and without WithPerCPUReaders.
+func BenchmarkHotShardReads(b *testing.B) {
+	for _, kind := range backends() {
+		for _, perCPU := range []bool{false, true} {
+			name := kind.String() + "/shared"
+			var opts []Option
+			if perCPU {
+				name = kind.String() + "/percpu"
+				opts = append(opts, WithPerCPUReaders())
+			}
+			b.Run(name, func(b *testing.B) {
+				lock := newTestLock(b, kind, 1, opts...)
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						lock.RLock(0)
+						lock.RUnlock(0)
+					}
+				})
+			})
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package cxlockrw
+
+import "sync"
+
+// Locker returns a sync.Locker that write-locks the given shard.
+func (lock *ShardedRWLock) Locker(shardnum uint32) sync.Locker {
+	return shardLocker{lock, shardnum}
+}
+
+// RLocker returns a sync.Locker that read-locks the given shard.
+func (lock *ShardedRWLock) RLocker(shardnum uint32) sync.Locker {
+	return shardRLocker{lock, shardnum}
+}
+
+type shardLocker struct {
+	lock  *ShardedRWLock
+	shard uint32
+}
+
+func (l shardLocker) Lock()   { l.lock.Lock(l.shard) }
+func (l shardLocker) Unlock() { l.lock.Unlock(l.shard) }
+
+type shardRLocker struct {
+	lock  *ShardedRWLock
+	shard uint32
+}
+
+func (l shardRLocker) Lock()   { l.lock.RLock(l.shard) }
+func (l shardRLocker) Unlock() { l.lock.RUnlock(l.shard) }
+
+// Cond is a condition variable for the write lock of one shard, like
+// sync.Cond with the shard's Locker. Wait must be called with the shard
+// write-locked, and the condition should only change under the write lock.
+// With BackendPthread it is a pthread_cond_t waited on together with the
+// shard's lock; for shared locks it only wakes waiters in this process.
+type Cond struct {
+	lock  *ShardedRWLock
+	shard uint32
+	c     shardCond
+}
+
+// shardCond is the backend's condition variable for a shard. wait releases
+// the caller's write lock, waits for signal or broadcast, and write-locks the
+// shard again before returning.
+type shardCond interface {
+	wait() error
+	signal()
+	broadcast()
+}
+
+// NewCond returns a condition variable for the write lock of the given shard.
+func (lock *ShardedRWLock) NewCond(shardnum uint32) *Cond {
+	c, err := lock.shards.newCond(shardnum)
+	if err != nil {
+		panic(wrapErr("cond", shardnum, err))
+	}
+	return &Cond{lock: lock, shard: shardnum, c: c}
+}
+
+// Wait unlocks the shard, waits to be woken by Signal or Broadcast, and
+// locks the shard again before returning. As with sync.Cond, the caller
+// should check its condition in a loop around Wait.
+func (c *Cond) Wait() {
+	must(c.WaitChecked())
+}
+
+// WaitChecked is like Wait but returns failures such as ErrNotHeld instead
+// of panicking.
+func (c *Cond) WaitChecked() error {
+	lock, shard := c.lock, c.shard
+	if lock.closed.Load() {
+		return wrapErr("wait", shard, ErrClosed)
+	}
+	g, err := lock.ownerRelease(shard, holdWrite)
+	if err != nil {
+		return wrapErr("wait", shard, err)
+	}
+	// The hold ends here, so the time spent waiting is not counted in it.
+	if lock.stats != nil {
+		lock.stats.released(shard, true, lock.stats.shard(shard).writeSince.Load())
+	}
+	// Optimistic readers may run while the shard is released.
+	seq := lock.shards.seq(shard)
+	seq.Add(1)
+	depReleased(lock, shard, holdWrite)
+	err = c.c.wait()
+	depAcquired(lock, shard, holdWrite)
+	seq.Add(1)
+	lock.ownerRecord(shard, g, holdWrite)
+	if err != nil {
+		return wrapErr("wait", shard, err)
+	}
+	if lock.stats != nil {
+		lock.stats.acquired(shard, true, -1)
+	}
+	return nil
+}
+
+// Signal wakes one goroutine waiting on c, if there is any.
+func (c *Cond) Signal() {
+	c.c.signal()
+}
+
+// Broadcast wakes all goroutines waiting on c.
+func (c *Cond) Broadcast() {
+	c.c.broadcast()
+}
+
+// goCond is a condition variable built on a backend's own write lock, used
+// by the backends without a native one. A waiter joins the queue before it
+// releases the shard, so a signal sent under the write lock cannot be lost.
+type goCond struct {
+	b       backend
+	shard   uint32
+	mu      sync.Mutex
+	waiters []chan struct{}
+}
+
+func newGoCond(b backend, shard uint32) *goCond {
+	return &goCond{b: b, shard: shard}
+}
+
+func (c *goCond) wait() error {
+	ready := make(chan struct{})
+	c.mu.Lock()
+	c.waiters = append(c.waiters, ready)
+	c.mu.Unlock()
+	if err := c.b.unlock(c.shard); err != nil {
+		c.mu.Lock()
+		for i, w := range c.waiters {
+			if w == ready {
+				c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+				break
+			}
+		}
+		c.mu.Unlock()
+		return err
+	}
+	<-ready
+	return c.b.lock(c.shard)
+}
+
+func (c *goCond) signal() {
+	c.mu.Lock()
+	if len(c.waiters) > 0 {
+		close(c.waiters[0])
+		c.waiters = c.waiters[1:]
+	}
+	c.mu.Unlock()
+}
+
+func (c *goCond) broadcast() {
+	c.mu.Lock()
+	for _, w := range c.waiters {
+		close(w)
+	}
+	c.waiters = nil
+	c.mu.Unlock()
+}
@@ -0,0 +1,152 @@
+package cxlockrw
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+)
+
+// condWaiters starts n goroutines that wait on c until tokens, guarded by
+// lock, allows them through, taking one token each. The returned channel
+// receives once per goroutine let through.
+func condWaiters(lock sync.Locker, c interface{ Wait() }, tokens *int, n int) <-chan struct{} {
+	woke := make(chan struct{}, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			runtime.LockOSThread()
+			defer runtime.UnlockOSThread()
+			lock.Lock()
+			for *tokens == 0 {
+				c.Wait()
+			}
+			*tokens--
+			lock.Unlock()
+			woke <- struct{}{}
+		}()
+	}
+	return woke
+}
+
+// wake hands out n tokens under lock and calls notify.
+func wake(lock sync.Locker, tokens *int, n int, notify func()) {
+	lock.Lock()
+	*tokens += n
+	notify()
+	lock.Unlock()
+}
+
+// Locker and RLocker must let a sync.Cond release and retake the shard.
+func TestLockerCond(t *testing.T) {
+	eachBackend(t, func(t *testing.T, b Backend) {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		lock := newTestLock(t, b, 2)
+		var tokens int
+		c := sync.NewCond(lock.Locker(1))
+		woke := condWaiters(c.L, c, &tokens, 1)
+		if acquiredWithin(woke) {
+			t.Fatal("a waiter went through without a token")
+		}
+		wake(c.L, &tokens, 1, c.Signal)
+		if !acquiredWithin(woke) {
+			t.Fatal("Signal did not wake the Locker waiter")
+		}
+
+		// Readers waiting on the cond must not keep the writer out.
+		c = sync.NewCond(lock.RLocker(1))
+		woke = condWaiters(c.L, c, &tokens, 2)
+		if acquiredWithin(woke) {
+			t.Fatal("a reader went through without a token")
+		}
+		wake(lock.Locker(1), &tokens, 2, c.Broadcast)
+		for i := 0; i < 2; i++ {
+			if !acquiredWithin(woke) {
+				t.Fatalf("Broadcast woke %d of 2 RLocker waiters", i)
+			}
+		}
+	})
+}
+
+func TestCondSignalBroadcast(t *testing.T) {
+	eachBackend(t, func(t *testing.T, b Backend) {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		lock := newTestLock(t, b, 2)
+		c := lock.NewCond(0)
+		var tokens int
+		woke := condWaiters(lock.Locker(0), c, &tokens, 3)
+		if acquiredWithin(woke) {
+			t.Fatal("a waiter went through without a token")
+		}
+		wake(lock.Locker(0), &tokens, 1, c.Signal)
+		if !acquiredWithin(woke) {
+			t.Fatal("Signal woke no waiter")
+		}
+		if acquiredWithin(woke) {
+			t.Fatal("two waiters went through on one token")
+		}
+		wake(lock.Locker(0), &tokens, 2, c.Broadcast)
+		for i := 0; i < 2; i++ {
+			if !acquiredWithin(woke) {
+				t.Fatalf("Broadcast woke %d of the 2 remaining waiters", i)
+			}
+		}
+	})
+}
+
+// While a writer waits on a Cond the shard is free, for readers and writers.
+func TestCondReleasesShard(t *testing.T) {
+	eachBackend(t, func(t *testing.T, b Backend) {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		lock := newTestLock(t, b, 1)
+		c := lock.NewCond(0)
+		var tokens int
+		woke := condWaiters(lock.Locker(0), c, &tokens, 1)
+		if acquiredWithin(woke) {
+			t.Fatal("the waiter went through without a token")
+		}
+		if ok, err := lock.TryRLock(0); !ok || err != nil {
+			t.Fatalf("TryRLock while the writer waited on the Cond = %v, %v, want true, nil", ok, err)
+		}
+		lock.RUnlock(0)
+		if ok, err := lock.TryLock(0); !ok || err != nil {
+			t.Fatalf("TryLock while the writer waited on the Cond = %v, %v, want true, nil", ok, err)
+		}
+		tokens++
+		c.Signal()
+		lock.Unlock(0)
+		if !acquiredWithin(woke) {
+			t.Fatal("Signal did not wake the waiter")
+		}
+	})
+}
+
+// The time spent in Wait is not part of the write hold.
+func TestCondStatsHold(t *testing.T) {
+	eachBackend(t, func(t *testing.T, b Backend) {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		lock := newTestLock(t, b, 1, WithStats())
+		c := lock.NewCond(0)
+		var tokens int
+		woke := condWaiters(lock.Locker(0), c, &tokens, 1)
+		if acquiredWithin(woke) {
+			t.Fatal("the waiter went through without a token")
+		}
+		wake(lock.Locker(0), &tokens, 1, c.Signal)
+		<-woke
+
+		w := lock.Stats()[0].Write
+		// The waiter's Lock, its return from Wait and wake's Lock.
+		if w.Acquires != 3 {
+			t.Errorf("write Acquires = %d, want 3", w.Acquires)
+		}
+		if w.Hold.Count() != 3 {
+			t.Errorf("write Hold count = %d, want 3", w.Hold.Count())
+		}
+		if w.Hold.Sum >= blocked {
+			t.Errorf("write Hold = %v, want well below the %v spent waiting", w.Hold.Sum, blocked)
+		}
+	})
+}
@@ -0,0 +1,55 @@
+package cxlockrw
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"syscall"
+)
+
+// Errors reported by the underlying locks, wrapped in a *LockError.
+// Match them with errors.Is.
+var (
+	// ErrDeadlock means the caller already holds the shard for writing, or,
+	// with WithOwnerCheck, in any mode that the acquisition would wait for.
+	ErrDeadlock error = syscall.EDEADLK
+	// ErrTooManyReaders means the shard's maximum number of read locks was reached.
+	ErrTooManyReaders error = syscall.EAGAIN
+	// ErrNotHeld means the caller released a shard it does not hold.
+	ErrNotHeld error = syscall.EPERM
+	// ErrInvalid means the shard's lock is not valid.
+	ErrInvalid error = syscall.EINVAL
+)
+
+var (
+	// ErrClosed means the ShardedRWLock has been closed.
+	ErrClosed = errors.New("lock is closed")
+	// ErrHeld means Close was called while a shard was held.
+	ErrHeld = errors.New("shard is still held")
+)
+
+// LockError records a failed operation on a shard.
+type LockError struct {
+	Op    string // "init", "rlock", "lock", "unlock", ...
+	Shard uint32
+	Err   error
+}
+
+// Error returns the error message.
+func (e *LockError) Error() string {
+	return "cxlockrw: " + e.Op + " shard " + strconv.FormatUint(uint64(e.Shard), 10) + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error, usually a syscall.Errno.
+func (e *LockError) Unwrap() error {
+	return e.Err
+}
+
+// wrapErr attaches op and shard to an error returned by a backend.
+// Context errors are passed through unchanged.
+func wrapErr(op string, shard uint32, err error) error {
+	if err == nil || err == context.Canceled || err == context.DeadlineExceeded {
+		return err
+	}
+	return &LockError{Op: op, Shard: shard, Err: err}
+}
@@ -0,0 +1,165 @@
+package cxlockrw
+
+import "sync/atomic"
+
+// Guard is a held shard, returned by Acquire and its variants. Release it,
+// typically with defer; releasing it again does nothing, so an early Release
+// on one path and a deferred one cannot unlock the shard twice.
+type Guard struct {
+	lock     *ShardedRWLock
+	shard    uint32
+	write    bool
+	released atomic.Bool
+}
+
+// Acquire write-locks the given shard and returns a Guard that unlocks it.
+func (lock *ShardedRWLock) Acquire(shardnum uint32) *Guard {
+	lock.Lock(shardnum)
+	return &Guard{lock: lock, shard: shardnum, write: true}
+}
+
+// RAcquire read-locks the given shard and returns a Guard that unlocks it.
+func (lock *ShardedRWLock) RAcquire(shardnum uint32) *Guard {
+	lock.RLock(shardnum)
+	return &Guard{lock: lock, shard: shardnum}
+}
+
+// AcquireKey write-locks the shard of key and returns a Guard that unlocks it.
+func (lock *ShardedRWLock) AcquireKey(key string) *Guard {
+	shard := lock.ShardOf(key)
+	lock.sampleKey(key, shard)
+	return lock.Acquire(shard)
+}
+
+// RAcquireKey read-locks the shard of key and returns a Guard that unlocks it.
+func (lock *ShardedRWLock) RAcquireKey(key string) *Guard {
+	shard := lock.ShardOf(key)
+	lock.sampleKey(key, shard)
+	return lock.RAcquire(shard)
+}
+
+// AcquireBytes write-locks the shard of key and returns a Guard that unlocks
+// it.
+func (lock *ShardedRWLock) AcquireBytes(key []byte) *Guard {
+	shard := lock.ShardOfBytes(key)
+	lock.sampleKeyBytes(key, shard)
+	return lock.Acquire(shard)
+}
+
+// RAcquireBytes read-locks the shard of key and returns a Guard that unlocks
+// it.
+func (lock *ShardedRWLock) RAcquireBytes(key []byte) *Guard {
+	shard := lock.ShardOfBytes(key)
+	lock.sampleKeyBytes(key, shard)
+	return lock.RAcquire(shard)
+}
+
+// AcquireUint64 write-locks the shard of key and returns a Guard that unlocks
+// it.
+func (lock *ShardedRWLock) AcquireUint64(key uint64) *Guard {
+	shard := lock.ShardOfUint64(key)
+	lock.sampleKeyUint64(key, shard)
+	return lock.Acquire(shard)
+}
+
+// RAcquireUint64 read-locks the shard of key and returns a Guard that unlocks
+// it.
+func (lock *ShardedRWLock) RAcquireUint64(key uint64) *Guard {
+	shard := lock.ShardOfUint64(key)
+	lock.sampleKeyUint64(key, shard)
+	return lock.RAcquire(shard)
+}
+
+// Shard returns the index of the guarded shard.
+func (g *Guard) Shard() uint32 {
+	return g.shard
+}
+
+// Release unlocks the shard the first time it is called.
+func (g *Guard) Release() {
+	must(g.ReleaseChecked())
+}
+
+// ReleaseChecked is like Release but returns the unlock failure instead of
+// panicking. A failed release counts as done.
+func (g *Guard) ReleaseChecked() error {
+	if !g.released.CompareAndSwap(false, true) {
+		return nil
+	}
+	if g.write {
+		return g.lock.UnlockChecked(g.shard)
+	}
+	return g.lock.RUnlockChecked(g.shard)
+}
+
+// WithLock write-locks the given shard, calls fn and unlocks the shard when
+// fn returns or panics. It returns fn's error, or the locking or unlocking
+// failure if fn succeeded.
+func (lock *ShardedRWLock) WithLock(shardnum uint32, fn func() error) (err error) {
+	if err := lock.LockChecked(shardnum); err != nil {
+		return err
+	}
+	defer func() {
+		if uerr := lock.UnlockChecked(shardnum); err == nil {
+			err = uerr
+		}
+	}()
+	return fn()
+}
+
+// WithRLock read-locks the given shard, calls fn and unlocks the shard when
+// fn returns or panics. It returns fn's error, or the locking or unlocking
+// failure if fn succeeded.
+func (lock *ShardedRWLock) WithRLock(shardnum uint32, fn func() error) (err error) {
+	if err := lock.RLockChecked(shardnum); err != nil {
+		return err
+	}
+	defer func() {
+		if uerr := lock.RUnlockChecked(shardnum); err == nil {
+			err = uerr
+		}
+	}()
+	return fn()
+}
+
+// WithLockKey is WithLock for the shard of key.
+func (lock *ShardedRWLock) WithLockKey(key string, fn func() error) error {
+	shard := lock.ShardOf(key)
+	lock.sampleKey(key, shard)
+	return lock.WithLock(shard, fn)
+}
+
+// WithRLockKey is WithRLock for the shard of key.
+func (lock *ShardedRWLock) WithRLockKey(key string, fn func() error) error {
+	shard := lock.ShardOf(key)
+	lock.sampleKey(key, shard)
+	return lock.WithRLock(shard, fn)
+}
+
+// WithLockBytes is WithLock for the shard of key.
+func (lock *ShardedRWLock) WithLockBytes(key []byte, fn func() error) error {
+	shard := lock.ShardOfBytes(key)
+	lock.sampleKeyBytes(key, shard)
+	return lock.WithLock(shard, fn)
+}
+
+// WithRLockBytes is WithRLock for the shard of key.
+func (lock *ShardedRWLock) WithRLockBytes(key []byte, fn func() error) error {
+	shard := lock.ShardOfBytes(key)
+	lock.sampleKeyBytes(key, shard)
+	return lock.WithRLock(shard, fn)
+}
+
+// WithLockUint64 is WithLock for the shard of key.
+func (lock *ShardedRWLock) WithLockUint64(key uint64, fn func() error) error {
+	shard := lock.ShardOfUint64(key)
+	lock.sampleKeyUint64(key, shard)
+	return lock.WithLock(shard, fn)
+}
+
+// WithRLockUint64 is WithRLock for the shard of key.
+func (lock *ShardedRWLock) WithRLockUint64(key uint64, fn func() error) error {
+	shard := lock.ShardOfUint64(key)
+	lock.sampleKeyUint64(key, shard)
+	return lock.WithRLock(shard, fn)
+}
@@ -0,0 +1,129 @@
+package cxlockrw
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+)
+
+// A second Release must not unlock the shard again, even after someone else
+// has taken it.
+func TestGuardReleaseTwice(t *testing.T) {
+	eachBackend(t, func(t *testing.T, b Backend) {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		lock := newTestLock(t, b, 2)
+		g := lock.Acquire(1)
+		if g.Shard() != 1 {
+			t.Fatalf("Shard = %d, want 1", g.Shard())
+		}
+		g.Release()
+		lock.RLock(1)
+		g.Release()
+		if err := g.ReleaseChecked(); err != nil {
+			t.Fatalf("third release: %v", err)
+		}
+		// The reader is still there, so the shard cannot be write-locked.
+		if ok, _ := lock.TryLock(1); ok {
+			t.Fatal("TryLock succeeded after a released Guard was released again")
+		}
+		lock.RUnlock(1)
+
+		g = lock.RAcquire(1)
+		g.Release()
+		g.Release()
+		if ok, err := lock.TryLock(1); !ok || err != nil {
+			t.Fatalf("TryLock after releasing a read Guard twice = %v, %v, want true, nil", ok, err)
+		}
+		lock.Unlock(1)
+	})
+}
+
+// WithLock and WithRLock must unlock when fn panics.
+func TestWithLockPanics(t *testing.T) {
+	eachBackend(t, func(t *testing.T, b Backend) {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		lock := newTestLock(t, b, 1)
+		for _, with := range []func(uint32, func() error) error{lock.WithLock, lock.WithRLock} {
+			func() {
+				defer func() {
+					if r := recover(); r != "boom" {
+						t.Errorf("recovered %v, want boom", r)
+					}
+				}()
+				with(0, func() error { panic("boom") })
+			}()
+			if ok, err := lock.TryLock(0); !ok || err != nil {
+				t.Fatalf("TryLock after fn panicked = %v, %v, want true, nil", ok, err)
+			}
+			lock.Unlock(0)
+		}
+	})
+}
+
+func TestWithLockErrors(t *testing.T) {
+	lock := newTestLock(t, BackendGo, 1)
+	errFn := errors.New("fn failed")
+	if err := lock.WithLock(0, func() error { return errFn }); err != errFn {
+		t.Fatalf("WithLock = %v, want fn's error", err)
+	}
+	if err := lock.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	called := false
+	if err := lock.WithRLock(0, func() error { called = true; return nil }); !errors.Is(err, ErrClosed) || called {
+		t.Fatalf("WithRLock after Close = %v and called fn = %v, want ErrClosed without calling fn", err, called)
+	}
+}
+
+// The key variants lock the shard the matching ShardOf function picks.
+func TestGuardKeys(t *testing.T) {
+	lock := newTestLock(t, BackendGo, 8)
+	key := []byte("some key")
+	tests := []struct {
+		name  string
+		shard uint32
+		g     func() *Guard
+		with  func(func() error) error
+	}{
+		{"Key", lock.ShardOf("k"), func() *Guard { return lock.AcquireKey("k") },
+			func(fn func() error) error { return lock.WithLockKey("k", fn) }},
+		{"RKey", lock.ShardOf("k"), func() *Guard { return lock.RAcquireKey("k") },
+			func(fn func() error) error { return lock.WithRLockKey("k", fn) }},
+		{"Bytes", lock.ShardOfBytes(key), func() *Guard { return lock.AcquireBytes(key) },
+			func(fn func() error) error { return lock.WithLockBytes(key, fn) }},
+		{"RBytes", lock.ShardOfBytes(key), func() *Guard { return lock.RAcquireBytes(key) },
+			func(fn func() error) error { return lock.WithRLockBytes(key, fn) }},
+		{"Uint64", lock.ShardOfUint64(42), func() *Guard { return lock.AcquireUint64(42) },
+			func(fn func() error) error { return lock.WithLockUint64(42, fn) }},
+		{"RUint64", lock.ShardOfUint64(42), func() *Guard { return lock.RAcquireUint64(42) },
+			func(fn func() error) error { return lock.WithRLockUint64(42, fn) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := tt.g()
+			if g.Shard() != tt.shard {
+				t.Errorf("Guard.Shard = %d, want %d", g.Shard(), tt.shard)
+			}
+			if ok, _ := lock.TryLock(tt.shard); ok {
+				t.Fatal("TryLock of the guarded shard succeeded")
+			}
+			g.Release()
+
+			err := tt.with(func() error {
+				if ok, _ := lock.TryLock(tt.shard); ok {
+					t.Error("TryLock of the shard inside fn succeeded")
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("With: %v", err)
+			}
+			if ok, err := lock.TryLock(tt.shard); !ok || err != nil {
+				t.Fatalf("TryLock after release = %v, %v, want true, nil", ok, err)
+			}
+			lock.Unlock(tt.shard)
+		})
+	}
+}
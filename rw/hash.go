@@ -0,0 +1,12 @@
+package cxlockrw
+
+import "github.com/cloudxaas/golock/internal/shardhash"
+
+// Hasher maps keys to 64-bit hashes used to select a shard.
+// Implementations must be deterministic and safe for concurrent use.
+type Hasher = shardhash.Hasher
+
+// FNV1a is the default Hasher. It hashes strings and byte slices with
+// 64-bit FNV-1a and mixes integer keys with the SplitMix64 finalizer.
+// It does not allocate and gives the same result in every process.
+type FNV1a = shardhash.FNV1a
@@ -0,0 +1,265 @@
+package cxlockrw
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HotSpots is a snapshot of the busiest shards and keys of a lock over its
+// hot-spot window.
+type HotSpots struct {
+	Window time.Duration
+	Shards []HotShard // by Contended, then Acquires, descending
+	Keys   []HotKey   // by Acquires, descending
+}
+
+// HotShard is the activity of one shard during the window.
+type HotShard struct {
+	Shard     uint32
+	Acquires  uint64
+	Contended uint64
+	Wait      time.Duration // total time spent waiting for the shard
+}
+
+// HotKey is the estimated activity of one key during the window. Keys are
+// sampled when locked through the key-based methods, so the counts are
+// estimates and rarely locked keys may be missing.
+type HotKey struct {
+	Key      string
+	Shard    uint32
+	Acquires uint64
+}
+
+// String formats the snapshot as a table for humans.
+func (h HotSpots) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "hot shards over the last %v:\n", h.Window)
+	fmt.Fprintf(&b, "  %8s %12s %12s %14s\n", "shard", "acquires", "contended", "wait")
+	for _, s := range h.Shards {
+		fmt.Fprintf(&b, "  %8d %12d %12d %14v\n", s.Shard, s.Acquires, s.Contended, s.Wait)
+	}
+	if len(h.Keys) > 0 {
+		fmt.Fprintf(&b, "hot keys (sampled):\n")
+		fmt.Fprintf(&b, "  %-32s %8s %12s\n", "key", "shard", "~acquires")
+		for _, k := range h.Keys {
+			fmt.Fprintf(&b, "  %-32s %8d %12d\n", strconv.Quote(k.Key), k.Shard, k.Acquires)
+		}
+	}
+	return b.String()
+}
+
+// HotSpots returns the n busiest shards and sampled keys over the window set
+// with WithHotSpots, or an empty snapshot if the option was not given. A
+// negative n is treated as 0.
+func (lock *ShardedRWLock) HotSpots(n int) HotSpots {
+	if n < 0 {
+		n = 0
+	}
+	if lock.hot == nil {
+		return HotSpots{}
+	}
+	return lock.hot.snapshot(n)
+}
+
+const (
+	// hotSlots is the number of sub-windows the window is divided into.
+	hotSlots = 8
+	// hotKeySampling is the fraction 1/hotKeySampling of key-based
+	// acquisitions that are sampled.
+	hotKeySampling = 32
+	// hotKeyCapacity is the number of keys tracked per sub-window.
+	hotKeyCapacity = 64
+)
+
+// hotSpots counts shard and key activity in hotSlots rotating sub-windows.
+// A sub-window is cleared by the first event that lands in it after it
+// expires, so counts racing with the clearing may be lost.
+type hotSpots struct {
+	base   time.Time
+	slot   time.Duration
+	n      int
+	shards []hotShard
+	step   int
+	keys   [hotSlots]hotKeySlot
+}
+
+// hotShard is a shard's sub-window counters. Its size is a multiple of the
+// cache line, so it needs no padding.
+type hotShard struct {
+	slots [hotSlots]hotCounters
+}
+
+type hotCounters struct {
+	epoch     atomic.Int64
+	acquires  atomic.Uint64
+	contended atomic.Uint64
+	wait      atomic.Int64
+}
+
+// hotKeySlot is a space-saving sketch of the keys sampled in one sub-window.
+type hotKeySlot struct {
+	mu    sync.Mutex
+	epoch int64
+	keys  map[string]*HotKey
+}
+
+func newHotSpots(numShards, line int, window time.Duration) *hotSpots {
+	h := &hotSpots{base: time.Now(), slot: window / hotSlots, n: numShards}
+	if h.slot <= 0 {
+		h.slot = 1
+	}
+	h.shards, h.step = alignedSlice[hotShard](numShards, line)
+	return h
+}
+
+// epoch returns the current sub-window number; it is never 0.
+func (h *hotSpots) epoch() int64 {
+	return int64(time.Since(h.base)/h.slot) + 1
+}
+
+// current returns the counters of the current sub-window of shard.
+func (h *hotSpots) current(shard uint32, epoch int64) *hotCounters {
+	c := &h.shards[int(shard)*h.step].slots[epoch%hotSlots]
+	if old := c.epoch.Load(); old != epoch && c.epoch.CompareAndSwap(old, epoch) {
+		c.acquires.Store(0)
+		c.contended.Store(0)
+		c.wait.Store(0)
+	}
+	return c
+}
+
+// record counts an acquisition of shard that waited wait, or did not wait
+// if wait is negative.
+func (h *hotSpots) record(shard uint32, wait int64) {
+	c := h.current(shard, h.epoch())
+	c.acquires.Add(1)
+	if wait >= 0 {
+		c.contended.Add(1)
+		c.wait.Add(wait)
+	}
+}
+
+// sampled reports whether a key-based acquisition should be sampled.
+func (h *hotSpots) sampled() bool {
+	return rand.Uint32()%hotKeySampling == 0
+}
+
+// sampleKey counts an acquisition of key in shard toward the hot keys, if
+// the lock tracks them and the acquisition is sampled.
+func (lock *ShardedRWLock) sampleKey(key string, shard uint32) {
+	if lock.hot != nil && lock.hot.sampled() {
+		lock.hot.sampleKey(key, shard)
+	}
+}
+
+// sampleKeyBytes is sampleKey for a byte slice key, which is only copied
+// when sampled.
+func (lock *ShardedRWLock) sampleKeyBytes(key []byte, shard uint32) {
+	if lock.hot != nil && lock.hot.sampled() {
+		lock.hot.sampleKey(string(key), shard)
+	}
+}
+
+// sampleKeyUint64 is sampleKey for an integer key, which is only formatted
+// when sampled.
+func (lock *ShardedRWLock) sampleKeyUint64(key uint64, shard uint32) {
+	if lock.hot != nil && lock.hot.sampled() {
+		lock.hot.sampleKey(strconv.FormatUint(key, 10), shard)
+	}
+}
+
+// sampleKey counts a sampled acquisition of key.
+func (h *hotSpots) sampleKey(key string, shard uint32) {
+	epoch := h.epoch()
+	s := &h.keys[epoch%hotSlots]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.epoch != epoch || s.keys == nil {
+		s.epoch = epoch
+		s.keys = make(map[string]*HotKey, hotKeyCapacity)
+	}
+	if k := s.keys[key]; k != nil {
+		k.Acquires++
+		return
+	}
+	if len(s.keys) < hotKeyCapacity {
+		s.keys[key] = &HotKey{Key: key, Shard: shard, Acquires: 1}
+		return
+	}
+	// Replace the least counted key, inheriting its count, so that a key
+	// that keeps coming back eventually overtakes the others.
+	var min *HotKey
+	for _, k := range s.keys {
+		if min == nil || k.Acquires < min.Acquires {
+			min = k
+		}
+	}
+	delete(s.keys, min.Key)
+	s.keys[key] = &HotKey{Key: key, Shard: shard, Acquires: min.Acquires + 1}
+}
+
+func (h *hotSpots) snapshot(n int) HotSpots {
+	now := h.epoch()
+	live := func(epoch int64) bool { return epoch > now-hotSlots && epoch <= now }
+	out := HotSpots{Window: h.slot * hotSlots}
+
+	for i := 0; i < h.n; i++ {
+		s := HotShard{Shard: uint32(i)}
+		for j := range h.shards[i*h.step].slots {
+			c := &h.shards[i*h.step].slots[j]
+			if live(c.epoch.Load()) {
+				s.Acquires += c.acquires.Load()
+				s.Contended += c.contended.Load()
+				s.Wait += time.Duration(c.wait.Load())
+			}
+		}
+		if s.Acquires > 0 {
+			out.Shards = append(out.Shards, s)
+		}
+	}
+	sort.Slice(out.Shards, func(i, j int) bool {
+		a, b := out.Shards[i], out.Shards[j]
+		if a.Contended != b.Contended {
+			return a.Contended > b.Contended
+		}
+		return a.Acquires > b.Acquires
+	})
+	if len(out.Shards) > n {
+		out.Shards = out.Shards[:n]
+	}
+
+	keys := make(map[string]*HotKey)
+	for i := range h.keys {
+		s := &h.keys[i]
+		s.mu.Lock()
+		if live(s.epoch) {
+			for key, k := range s.keys {
+				if sum := keys[key]; sum != nil {
+					sum.Acquires += k.Acquires * hotKeySampling
+				} else {
+					keys[key] = &HotKey{Key: key, Shard: k.Shard, Acquires: k.Acquires * hotKeySampling}
+				}
+			}
+		}
+		s.mu.Unlock()
+	}
+	for _, k := range keys {
+		out.Keys = append(out.Keys, *k)
+	}
+	sort.Slice(out.Keys, func(i, j int) bool {
+		if out.Keys[i].Acquires != out.Keys[j].Acquires {
+			return out.Keys[i].Acquires > out.Keys[j].Acquires
+		}
+		return out.Keys[i].Key < out.Keys[j].Key
+	})
+	if len(out.Keys) > n {
+		out.Keys = out.Keys[:n]
+	}
+	return out
+}
@@ -0,0 +1,36 @@
+package cxlockrw
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHotSpots(t *testing.T) {
+	lock := newTestLock(t, BackendGo, 8, WithHotSpots(time.Minute))
+	const acquires = 3200
+	for i := 0; i < acquires; i++ {
+		lock.LockKey("hot")
+		lock.UnlockKey("hot")
+	}
+	lock.RLockUint64(7)
+	lock.RUnlockUint64(7)
+
+	hs := lock.HotSpots(1)
+	if len(hs.Shards) != 1 || hs.Shards[0].Shard != lock.ShardOf("hot") {
+		t.Fatalf("Shards = %+v, want the shard of %q", hs.Shards, "hot")
+	}
+	if hs.Shards[0].Acquires < acquires {
+		t.Errorf("shard acquires = %d, want at least %d", hs.Shards[0].Acquires, acquires)
+	}
+	if len(hs.Keys) != 1 || hs.Keys[0].Key != "hot" {
+		t.Fatalf("Keys = %+v, want %q", hs.Keys, "hot")
+	}
+	// Sampled keys are scaled up, so expect the count within a wide margin.
+	if n := hs.Keys[0].Acquires; n < acquires/2 || n > acquires*3/2 {
+		t.Errorf("key acquires = %d, want about %d", n, acquires)
+	}
+
+	if hs := lock.HotSpots(-1); len(hs.Shards) != 0 || len(hs.Keys) != 0 {
+		t.Errorf("HotSpots(-1) = %+v, want no shards or keys", hs)
+	}
+}
@@ -0,0 +1,249 @@
+package cxlockrw
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// KeyedLock is a read-write lock per distinct key. Unlike the key-based
+// methods of ShardedRWLock, keys that hash to the same shard never block each
+// other.
+//
+// Per-key locks are pure-Go read-write locks, created when a key is first
+// locked and freed when its last holder or waiter leaves. The keys are kept
+// in one table per shard of a ShardedRWLock, whose shard locks only guard the
+// tables and are never held while waiting for a key.
+type KeyedLock struct {
+	table  *ShardedRWLock
+	keys   []map[string]*keyEntry // indexed by shard
+	policy Policy
+}
+
+// keyEntry is the lock of one key and the number of goroutines holding or
+// waiting for it.
+type keyEntry struct {
+	goRWLock
+	refs atomic.Int32
+}
+
+var keyEntryPool = sync.Pool{
+	New: func() any {
+		return new(keyEntry)
+	},
+}
+
+// NewKeyedLock creates a KeyedLock whose table has numShards shards. The
+// options configure the table's ShardedRWLock; WithPolicy also applies to
+// the per-key locks. It panics if the table cannot be created.
+func NewKeyedLock(numShards int, opts ...Option) *KeyedLock {
+	table := NewShardedRWLock(numShards, opts...)
+	cfg := newConfig(opts)
+	kl := &KeyedLock{
+		table:  table,
+		keys:   make([]map[string]*keyEntry, numShards),
+		policy: cfg.policy,
+	}
+	for i := range kl.keys {
+		kl.keys[i] = make(map[string]*keyEntry)
+	}
+	return kl
+}
+
+// Len returns the number of keys currently held or waited for.
+func (kl *KeyedLock) Len() int {
+	n := 0
+	for i := range kl.keys {
+		kl.table.RLock(uint32(i))
+		n += len(kl.keys[i])
+		kl.table.RUnlock(uint32(i))
+	}
+	return n
+}
+
+// Close releases the table. It fails with ErrHeld while any key is held or
+// waited for, and is idempotent like ShardedRWLock.Close. The keys are
+// checked while the table refuses new acquisitions, so no key can be locked
+// between the check and the close.
+func (kl *KeyedLock) Close() error {
+	return kl.table.closeIf(func() error {
+		for i := range kl.keys {
+			if len(kl.keys[i]) != 0 {
+				return &LockError{Op: "close", Shard: uint32(i), Err: ErrHeld}
+			}
+		}
+		return nil
+	})
+}
+
+// acquire returns the entry for key with a reference taken, creating it if
+// needed.
+func (kl *KeyedLock) acquire(op, key string) (*keyEntry, uint32, error) {
+	shard := kl.table.ShardOf(key)
+	if err := kl.table.RLockChecked(shard); err != nil {
+		return nil, shard, wrapOp(op, err)
+	}
+	e := kl.keys[shard][key]
+	if e != nil {
+		e.refs.Add(1)
+	}
+	kl.table.RUnlock(shard)
+	if e != nil {
+		return e, shard, nil
+	}
+
+	if err := kl.table.LockChecked(shard); err != nil {
+		return nil, shard, wrapOp(op, err)
+	}
+	e = kl.keys[shard][key]
+	if e == nil {
+		e = keyEntryPool.Get().(*keyEntry)
+		e.policy = kl.policy
+		kl.keys[shard][key] = e
+	}
+	e.refs.Add(1)
+	kl.table.Unlock(shard)
+	return e, shard, nil
+}
+
+// lookup returns the entry of a key the caller holds.
+func (kl *KeyedLock) lookup(op, key string) (*keyEntry, uint32, error) {
+	shard := kl.table.ShardOf(key)
+	if err := kl.table.RLockChecked(shard); err != nil {
+		return nil, shard, wrapOp(op, err)
+	}
+	e := kl.keys[shard][key]
+	kl.table.RUnlock(shard)
+	if e == nil {
+		return nil, shard, &LockError{Op: op, Shard: shard, Err: ErrNotHeld}
+	}
+	return e, shard, nil
+}
+
+// release drops a reference to e and frees it if it was the last one.
+func (kl *KeyedLock) release(e *keyEntry, shard uint32, key string) {
+	if e.refs.Add(-1) != 0 {
+		return
+	}
+	kl.table.Lock(shard)
+	// Someone may have found e again between the decrement and Lock.
+	free := e.refs.Load() == 0 && kl.keys[shard][key] == e
+	if free {
+		delete(kl.keys[shard], key)
+	}
+	kl.table.Unlock(shard)
+	if free {
+		keyEntryPool.Put(e)
+	}
+}
+
+// wrapOp renames the operation of a table error after the KeyedLock method
+// that caused it.
+func wrapOp(op string, err error) error {
+	if le, ok := err.(*LockError); ok {
+		return &LockError{Op: op, Shard: le.Shard, Err: le.Err}
+	}
+	return err
+}
+
+// RLock acquires a read lock for key.
+func (kl *KeyedLock) RLock(key string) {
+	must(kl.RLockContext(context.Background(), key))
+}
+
+// RUnlock releases a read lock for key.
+func (kl *KeyedLock) RUnlock(key string) {
+	must(kl.RUnlockChecked(key))
+}
+
+// Lock acquires a write lock for key.
+func (kl *KeyedLock) Lock(key string) {
+	must(kl.LockContext(context.Background(), key))
+}
+
+// Unlock releases a write lock for key.
+func (kl *KeyedLock) Unlock(key string) {
+	must(kl.UnlockChecked(key))
+}
+
+// RLockContext acquires a read lock for key, waiting until ctx is done.
+// It returns ctx.Err() if the lock could not be acquired in time.
+func (kl *KeyedLock) RLockContext(ctx context.Context, key string) error {
+	e, shard, err := kl.acquire("rlock", key)
+	if err != nil {
+		return err
+	}
+	if err := e.rlockContext(ctx); err != nil {
+		kl.release(e, shard, key)
+		return err
+	}
+	return nil
+}
+
+// LockContext acquires a write lock for key, waiting until ctx is done.
+// It returns ctx.Err() if the lock could not be acquired in time.
+func (kl *KeyedLock) LockContext(ctx context.Context, key string) error {
+	e, shard, err := kl.acquire("lock", key)
+	if err != nil {
+		return err
+	}
+	if err := e.lockContext(ctx); err != nil {
+		kl.release(e, shard, key)
+		return err
+	}
+	return nil
+}
+
+// TryRLock acquires a read lock for key if that is possible without waiting.
+func (kl *KeyedLock) TryRLock(key string) (bool, error) {
+	e, shard, err := kl.acquire("tryrlock", key)
+	if err != nil {
+		return false, err
+	}
+	if !e.tryRLock() {
+		kl.release(e, shard, key)
+		return false, nil
+	}
+	return true, nil
+}
+
+// TryLock acquires a write lock for key if no one else holds it.
+func (kl *KeyedLock) TryLock(key string) (bool, error) {
+	e, shard, err := kl.acquire("trylock", key)
+	if err != nil {
+		return false, err
+	}
+	if !e.tryLock() {
+		kl.release(e, shard, key)
+		return false, nil
+	}
+	return true, nil
+}
+
+// RUnlockChecked releases a read lock for key, reporting ErrNotHeld if key
+// is not read-locked.
+func (kl *KeyedLock) RUnlockChecked(key string) error {
+	e, shard, err := kl.lookup("runlock", key)
+	if err != nil {
+		return err
+	}
+	if err := e.runlock(); err != nil {
+		return &LockError{Op: "runlock", Shard: shard, Err: err}
+	}
+	kl.release(e, shard, key)
+	return nil
+}
+
+// UnlockChecked releases a write lock for key, reporting ErrNotHeld if key
+// is not write-locked.
+func (kl *KeyedLock) UnlockChecked(key string) error {
+	e, shard, err := kl.lookup("unlock", key)
+	if err != nil {
+		return err
+	}
+	if err := e.unlock(); err != nil {
+		return &LockError{Op: "unlock", Shard: shard, Err: err}
+	}
+	kl.release(e, shard, key)
+	return nil
+}
@@ -0,0 +1,135 @@
+package cxlockrw
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestKeyedLockExcludesPerKey(t *testing.T) {
+	// One table shard, so both keys share it.
+	kl := NewKeyedLock(1, WithBackend(BackendGo))
+	defer kl.Close()
+	kl.Lock("a")
+	if ok, err := kl.TryLock("a"); ok || err != nil {
+		t.Errorf("TryLock of a write-locked key = %v, %v, want false, nil", ok, err)
+	}
+	if ok, err := kl.TryRLock("a"); ok || err != nil {
+		t.Errorf("TryRLock of a write-locked key = %v, %v, want false, nil", ok, err)
+	}
+	if ok, err := kl.TryLock("b"); !ok || err != nil {
+		t.Errorf("TryLock of another key = %v, %v, want true, nil", ok, err)
+	} else {
+		kl.Unlock("b")
+	}
+	kl.Unlock("a")
+
+	kl.RLock("a")
+	if ok, err := kl.TryRLock("a"); !ok || err != nil {
+		t.Errorf("TryRLock beside a reader = %v, %v, want true, nil", ok, err)
+	} else {
+		kl.RUnlock("a")
+	}
+	if ok, err := kl.TryLock("a"); ok || err != nil {
+		t.Errorf("TryLock of a read-locked key = %v, %v, want false, nil", ok, err)
+	}
+	kl.RUnlock("a")
+}
+
+// An entry lives exactly as long as someone holds or waits for its key.
+func TestKeyedLockFreesEntries(t *testing.T) {
+	kl := NewKeyedLock(4, WithBackend(BackendGo))
+	defer kl.Close()
+	kl.Lock("a")
+	kl.RLock("b")
+	kl.RLock("b")
+	if n := kl.Len(); n != 2 {
+		t.Fatalf("Len with two keys held = %d, want 2", n)
+	}
+	shard := kl.table.ShardOf("b")
+	if refs := kl.keys[shard]["b"].refs.Load(); refs != 2 {
+		t.Errorf("refs of a key read-locked twice = %d, want 2", refs)
+	}
+	kl.RUnlock("b")
+	if n := kl.Len(); n != 2 {
+		t.Fatalf("Len with a reader left = %d, want 2", n)
+	}
+	kl.RUnlock("b")
+	kl.Unlock("a")
+	if n := kl.Len(); n != 0 {
+		t.Fatalf("Len after the last Unlock = %d, want 0", n)
+	}
+	if err := kl.UnlockChecked("a"); !errors.Is(err, ErrNotHeld) {
+		t.Errorf("UnlockChecked of a freed key = %v, want ErrNotHeld", err)
+	}
+
+	// A timed-out waiter drops its reference too.
+	kl.Lock("a")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := kl.LockContext(ctx, "a"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("LockContext with a cancelled context = %v, want context.Canceled", err)
+	}
+	kl.Unlock("a")
+	if n := kl.Len(); n != 0 {
+		t.Fatalf("Len after a cancelled waiter = %d, want 0", n)
+	}
+}
+
+func TestKeyedLockClose(t *testing.T) {
+	kl := NewKeyedLock(4, WithBackend(BackendGo))
+	kl.RLock("a")
+	if err := kl.Close(); !errors.Is(err, ErrHeld) {
+		t.Fatalf("Close with a key held = %v, want ErrHeld", err)
+	}
+	kl.RUnlock("a")
+	if err := kl.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := kl.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+	if err := kl.LockContext(context.Background(), "a"); !errors.Is(err, ErrClosed) {
+		t.Fatalf("LockContext after Close = %v, want ErrClosed", err)
+	}
+}
+
+// Close racing with lockers must either refuse or leave them failing with
+// ErrClosed, never close the table under a held key.
+func TestKeyedLockCloseRace(t *testing.T) {
+	for round := 0; round < 20; round++ {
+		kl := NewKeyedLock(4, WithBackend(BackendGo))
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				key := strconv.Itoa(i)
+				for {
+					if err := kl.LockContext(context.Background(), key); err != nil {
+						if !errors.Is(err, ErrClosed) {
+							t.Errorf("LockContext = %v, want nil or ErrClosed", err)
+						}
+						return
+					}
+					if err := kl.UnlockChecked(key); err != nil {
+						t.Errorf("UnlockChecked of a held key: %v", err)
+						return
+					}
+				}
+			}()
+		}
+		for {
+			err := kl.Close()
+			if err == nil {
+				break
+			}
+			if !errors.Is(err, ErrHeld) {
+				t.Fatalf("Close = %v, want nil or ErrHeld", err)
+			}
+		}
+		wg.Wait()
+	}
+}
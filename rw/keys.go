@@ -0,0 +1,98 @@
+package cxlockrw
+
+// NumShards returns the number of shards in the lock.
+func (lock *ShardedRWLock) NumShards() int {
+	return lock.numShards
+}
+
+// shardOf reduces a hash to a shard index.
+func (lock *ShardedRWLock) shardOf(hash uint64) uint32 {
+	return uint32(hash % uint64(lock.numShards))
+}
+
+// ShardOf returns the shard index used for a string key.
+func (lock *ShardedRWLock) ShardOf(key string) uint32 {
+	return lock.shardOf(lock.hasher.HashString(key))
+}
+
+// ShardOfBytes returns the shard index used for a byte slice key.
+func (lock *ShardedRWLock) ShardOfBytes(key []byte) uint32 {
+	return lock.shardOf(lock.hasher.HashBytes(key))
+}
+
+// ShardOfUint64 returns the shard index used for an integer key.
+func (lock *ShardedRWLock) ShardOfUint64(key uint64) uint32 {
+	return lock.shardOf(lock.hasher.HashUint64(key))
+}
+
+// RLockKey acquires a read lock for the shard corresponding to the provided key.
+func (lock *ShardedRWLock) RLockKey(key string) {
+	shard := lock.ShardOf(key)
+	lock.sampleKey(key, shard)
+	lock.RLock(shard)
+}
+
+// RUnlockKey releases a read lock for the shard corresponding to the provided key.
+func (lock *ShardedRWLock) RUnlockKey(key string) {
+	lock.RUnlock(lock.ShardOf(key))
+}
+
+// LockKey acquires a write lock for the shard corresponding to the provided key.
+func (lock *ShardedRWLock) LockKey(key string) {
+	shard := lock.ShardOf(key)
+	lock.sampleKey(key, shard)
+	lock.Lock(shard)
+}
+
+// UnlockKey releases a write lock for the shard corresponding to the provided key.
+func (lock *ShardedRWLock) UnlockKey(key string) {
+	lock.Unlock(lock.ShardOf(key))
+}
+
+// RLockBytes acquires a read lock for the shard corresponding to the provided key.
+func (lock *ShardedRWLock) RLockBytes(key []byte) {
+	shard := lock.ShardOfBytes(key)
+	lock.sampleKeyBytes(key, shard)
+	lock.RLock(shard)
+}
+
+// RUnlockBytes releases a read lock for the shard corresponding to the provided key.
+func (lock *ShardedRWLock) RUnlockBytes(key []byte) {
+	lock.RUnlock(lock.ShardOfBytes(key))
+}
+
+// LockBytes acquires a write lock for the shard corresponding to the provided key.
+func (lock *ShardedRWLock) LockBytes(key []byte) {
+	shard := lock.ShardOfBytes(key)
+	lock.sampleKeyBytes(key, shard)
+	lock.Lock(shard)
+}
+
+// UnlockBytes releases a write lock for the shard corresponding to the provided key.
+func (lock *ShardedRWLock) UnlockBytes(key []byte) {
+	lock.Unlock(lock.ShardOfBytes(key))
+}
+
+// RLockUint64 acquires a read lock for the shard corresponding to the provided key.
+func (lock *ShardedRWLock) RLockUint64(key uint64) {
+	shard := lock.ShardOfUint64(key)
+	lock.sampleKeyUint64(key, shard)
+	lock.RLock(shard)
+}
+
+// RUnlockUint64 releases a read lock for the shard corresponding to the provided key.
+func (lock *ShardedRWLock) RUnlockUint64(key uint64) {
+	lock.RUnlock(lock.ShardOfUint64(key))
+}
+
+// LockUint64 acquires a write lock for the shard corresponding to the provided key.
+func (lock *ShardedRWLock) LockUint64(key uint64) {
+	shard := lock.ShardOfUint64(key)
+	lock.sampleKeyUint64(key, shard)
+	lock.Lock(shard)
+}
+
+// UnlockUint64 releases a write lock for the shard corresponding to the provided key.
+func (lock *ShardedRWLock) UnlockUint64(key uint64) {
+	lock.Unlock(lock.ShardOfUint64(key))
+}
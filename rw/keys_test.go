@@ -0,0 +1,106 @@
+package cxlockrw
+
+import (
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+// constHasher hashes every key of a kind to the same value.
+type constHasher struct{ s, b, u uint64 }
+
+func (h constHasher) HashString(string) uint64 { return h.s }
+func (h constHasher) HashBytes([]byte) uint64  { return h.b }
+func (h constHasher) HashUint64(uint64) uint64 { return h.u }
+
+func TestShardOfDefaultHasher(t *testing.T) {
+	const n = 61
+	for _, opts := range [][]Option{nil, {WithHasher(nil)}} {
+		lock := newTestLock(t, BackendGo, n, opts...)
+		for i := 0; i < 100; i++ {
+			k := "key-" + strconv.Itoa(i)
+			want := uint32(FNV1a{}.HashString(k) % n)
+			if got := lock.ShardOf(k); got != want {
+				t.Errorf("ShardOf(%q) = %d, want %d", k, got, want)
+			}
+			if got := lock.ShardOfBytes([]byte(k)); got != want {
+				t.Errorf("ShardOfBytes(%q) = %d, want %d", k, got, want)
+			}
+			u := uint64(i)
+			if got, want := lock.ShardOfUint64(u), uint32(FNV1a{}.HashUint64(u)%n); got != want {
+				t.Errorf("ShardOfUint64(%d) = %d, want %d", u, got, want)
+			}
+		}
+	}
+	// 64-bit FNV-1a of "a".
+	if got := (FNV1a{}).HashString("a"); got != 0xaf63dc4c8601ec8c {
+		t.Errorf("FNV1a.HashString(\"a\") = %#x, want 0xaf63dc4c8601ec8c", got)
+	}
+}
+
+func TestShardOfCustomHasher(t *testing.T) {
+	lock := newTestLock(t, BackendGo, 8, WithHasher(constHasher{s: 3, b: 13, u: 22}))
+	if got := lock.ShardOf("x"); got != 3 {
+		t.Errorf("ShardOf = %d, want 3", got)
+	}
+	if got := lock.ShardOfBytes([]byte("x")); got != 5 {
+		t.Errorf("ShardOfBytes = %d, want 5", got)
+	}
+	if got := lock.ShardOfUint64(1); got != 6 {
+		t.Errorf("ShardOfUint64 = %d, want 6", got)
+	}
+}
+
+// The key methods must lock the shard that ShardOf reports for the key.
+func TestLockKeyShard(t *testing.T) {
+	tests := []struct {
+		name   string
+		shard  func(lock *ShardedRWLock) uint32
+		lock   func(lock *ShardedRWLock)
+		unlock func(lock *ShardedRWLock)
+	}{
+		{
+			name:   "string",
+			shard:  func(lock *ShardedRWLock) uint32 { return lock.ShardOf("alpha") },
+			lock:   func(lock *ShardedRWLock) { lock.LockKey("alpha") },
+			unlock: func(lock *ShardedRWLock) { lock.UnlockKey("alpha") },
+		},
+		{
+			name:   "bytes",
+			shard:  func(lock *ShardedRWLock) uint32 { return lock.ShardOfBytes([]byte("alpha")) },
+			lock:   func(lock *ShardedRWLock) { lock.LockBytes([]byte("alpha")) },
+			unlock: func(lock *ShardedRWLock) { lock.UnlockBytes([]byte("alpha")) },
+		},
+		{
+			name:   "uint64",
+			shard:  func(lock *ShardedRWLock) uint32 { return lock.ShardOfUint64(42) },
+			lock:   func(lock *ShardedRWLock) { lock.LockUint64(42) },
+			unlock: func(lock *ShardedRWLock) { lock.UnlockUint64(42) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eachBackend(t, func(t *testing.T, b Backend) {
+				runtime.LockOSThread()
+				defer runtime.UnlockOSThread()
+				const n = 16
+				lock := newTestLock(t, b, n)
+				want := tt.shard(lock)
+				tt.lock(lock)
+				for shard := uint32(0); shard < n; shard++ {
+					ok := other(func() bool {
+						ok, _ := lock.TryRLock(shard)
+						if ok {
+							lock.RUnlock(shard)
+						}
+						return ok
+					})
+					if ok == (shard == want) {
+						t.Errorf("shard %d: TryRLock = %v with shard %d locked", shard, ok, want)
+					}
+				}
+				tt.unlock(lock)
+			})
+		})
+	}
+}
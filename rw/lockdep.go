@@ -0,0 +1,316 @@
+//go:build cxlockdebug
+// +build cxlockdebug
+
+package cxlockrw
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Built with the cxlockdebug tag, every ShardedRWLock records which shards
+// each goroutine holds and in which order, and keeps a graph of the orders
+// seen across all locks of the process. Acquisitions that could deadlock are
+// logged with the stacks involved the first time they are seen:
+//
+//   - taking two shards in the opposite order of an earlier acquisition,
+//     possibly through other shards in between;
+//   - locking a shard the goroutine already holds for writing;
+//   - write-locking or upgrading a shard the goroutine holds for reading.
+//
+// Shards are told apart by lock and index, so shards of the same lock taken
+// in both orders are reported too. Read locks are treated like write locks
+// when ordering, so an inversion involving only readers is reported even
+// though it deadlocks only if writers queue up in between.
+//
+// The orders of a lock are forgotten when it is closed or garbage collected.
+// At most depMaxOrders orders are kept; once the graph is full, new orders
+// are no longer checked, which is reported each time it fills up.
+
+// depNode is a shard of a lock.
+type depNode struct {
+	lock  uint64
+	shard uint32
+}
+
+func (n depNode) String() string {
+	return "lock " + strconv.FormatUint(n.lock, 10) + " shard " + strconv.FormatUint(uint64(n.shard), 10)
+}
+
+// depStack is the stack of an acquisition.
+type depStack struct {
+	pcs [32]uintptr
+	n   int
+}
+
+func captureStack(skip int) depStack {
+	var s depStack
+	s.n = runtime.Callers(skip+2, s.pcs[:])
+	return s
+}
+
+func (s *depStack) String() string {
+	return formatStack(s.pcs[:s.n])
+}
+
+// depHeld is a shard held by a goroutine.
+type depHeld struct {
+	node  depNode
+	mode  holdMode
+	stack depStack
+}
+
+// depMaxOrders bounds the number of orders in the lock-order graph.
+var depMaxOrders = 1 << 16
+
+var lockdep struct {
+	ids      atomic.Uint64
+	reporter atomic.Pointer[func(report string)]
+
+	mu   sync.Mutex
+	held map[int64][]depHeld // by goroutine
+	// after[a][b] is the stack that first took b while holding a.
+	after  map[depNode]map[depNode]*depStack
+	orders int  // entries in after
+	full   bool // an order was dropped because the graph was full
+	// pending holds the reports found under mu, to be sent by depFlush.
+	pending []string
+}
+
+// SetDeadlockReporter sets the function that receives the reports of the
+// cxlockdebug lock-order checks, which are logged with log.Print by default.
+// A nil fn restores the default. fn runs on the goroutine that made the
+// suspicious acquisition, before it waits, and may use the locks itself.
+func SetDeadlockReporter(fn func(report string)) {
+	if fn == nil {
+		lockdep.reporter.Store(nil)
+		return
+	}
+	lockdep.reporter.Store(&fn)
+}
+
+// depRegister gives lock its id in the lock-order graph.
+func depRegister(lock *ShardedRWLock) {
+	lock.depID = lockdep.ids.Add(1)
+}
+
+// depClosed forgets the orders involving lock's shards.
+func depClosed(lock *ShardedRWLock) {
+	lockdep.mu.Lock()
+	defer lockdep.mu.Unlock()
+	for a, next := range lockdep.after {
+		if a.lock == lock.depID {
+			lockdep.orders -= len(next)
+			delete(lockdep.after, a)
+			continue
+		}
+		for b := range next {
+			if b.lock == lock.depID {
+				lockdep.orders--
+				delete(next, b)
+			}
+		}
+		if len(next) == 0 {
+			delete(lockdep.after, a)
+		}
+	}
+	lockdep.full = lockdep.full && lockdep.orders >= depMaxOrders
+}
+
+// depAcquire checks a blocking acquisition of shard in mode against the
+// shards the goroutine already holds, before it waits.
+func depAcquire(lock *ShardedRWLock, shard uint32, mode holdMode) {
+	n := depNode{lock.depID, shard}
+	stack := captureStack(1)
+	defer depFlush()
+	lockdep.mu.Lock()
+	defer lockdep.mu.Unlock()
+	held := lockdep.held[goid()]
+	for i := range held {
+		h := &held[i]
+		if h.node == n {
+			switch {
+			case h.mode == holdWrite:
+				depReport("recursive "+mode.String()+" lock of "+n.String()+", already write-locked by this goroutine", &stack, h)
+			case mode == holdWrite:
+				depReport("write lock of "+n.String()+", already "+h.mode.String()+"-locked by this goroutine", &stack, h)
+			case mode == holdUpgradable && h.mode == holdUpgradable:
+				depReport("recursive upgradable read lock of "+n.String(), &stack, h)
+			}
+			continue
+		}
+		if _, ok := lockdep.after[h.node][n]; ok {
+			continue
+		}
+		if path := depPath(n, h.node); path != nil {
+			var b strings.Builder
+			b.WriteString("lock order inversion: taking " + n.String() + " while holding " + h.node.String() +
+				", but the reverse order was seen before:\n")
+			for _, step := range path {
+				b.WriteString(step.from.String() + " then " + step.to.String() + " at:\n" + step.stack.String())
+			}
+			depReport(b.String(), &stack, h)
+		}
+		if lockdep.orders >= depMaxOrders {
+			if !lockdep.full {
+				lockdep.full = true
+				lockdep.pending = append(lockdep.pending, "lock-order graph holds "+strconv.Itoa(lockdep.orders)+
+					" orders; new orders are no longer checked")
+			}
+			continue
+		}
+		if lockdep.after == nil {
+			lockdep.after = make(map[depNode]map[depNode]*depStack)
+		}
+		lockdep.orders++
+		next := lockdep.after[h.node]
+		if next == nil {
+			next = make(map[depNode]*depStack)
+			lockdep.after[h.node] = next
+		}
+		s := stack
+		next[n] = &s
+	}
+}
+
+// depEdge is a step of a path in the lock-order graph.
+type depEdge struct {
+	from, to depNode
+	stack    *depStack
+}
+
+// depPath returns a path of recorded orders from a to b, or nil.
+// lockdep.mu must be held.
+func depPath(a, b depNode) []depEdge {
+	seen := map[depNode]bool{a: true}
+	var walk func(n depNode) []depEdge
+	walk = func(n depNode) []depEdge {
+		for next, stack := range lockdep.after[n] {
+			e := depEdge{n, next, stack}
+			if next == b {
+				return []depEdge{e}
+			}
+			if seen[next] {
+				continue
+			}
+			seen[next] = true
+			if path := walk(next); path != nil {
+				return append([]depEdge{e}, path...)
+			}
+		}
+		return nil
+	}
+	return walk(a)
+}
+
+// depReport queues the report of a possible deadlock found while acquiring
+// at stack, with the acquisition of h that conflicts with it.
+// lockdep.mu must be held.
+func depReport(what string, stack *depStack, h *depHeld) {
+	lockdep.pending = append(lockdep.pending, fmt.Sprintf("possible deadlock: %s\nacquiring at:\n%sholding %s (%s) since:\n%s",
+		what, stack, h.node, h.mode, &h.stack))
+}
+
+// depFlush sends the queued reports to the reporter. lockdep.mu must not be
+// held, so that the reporter may take locks.
+func depFlush() {
+	lockdep.mu.Lock()
+	pending := lockdep.pending
+	lockdep.pending = nil
+	lockdep.mu.Unlock()
+	report := func(r string) { log.Print("cxlockrw: " + r) }
+	if fn := lockdep.reporter.Load(); fn != nil {
+		report = *fn
+	}
+	for _, r := range pending {
+		report(r)
+	}
+}
+
+// depAcquired records that the goroutine now holds shard in mode.
+func depAcquired(lock *ShardedRWLock, shard uint32, mode holdMode) {
+	h := depHeld{node: depNode{lock.depID, shard}, mode: mode, stack: captureStack(1)}
+	lockdep.mu.Lock()
+	defer lockdep.mu.Unlock()
+	if lockdep.held == nil {
+		lockdep.held = make(map[int64][]depHeld)
+	}
+	id := goid()
+	lockdep.held[id] = append(lockdep.held[id], h)
+}
+
+// depReleased records the release of shard held in mode. Read locks, and
+// write locks of the Go backend, may be released by another goroutine than
+// the one that took them.
+func depReleased(lock *ShardedRWLock, shard uint32, mode holdMode) {
+	lockdep.mu.Lock()
+	defer lockdep.mu.Unlock()
+	if h := depFind(lock, shard, mode); h != nil {
+		id, i := h.id, h.i
+		held := lockdep.held[id]
+		lockdep.held[id] = append(held[:i], held[i+1:]...)
+		if len(lockdep.held[id]) == 0 {
+			delete(lockdep.held, id)
+		}
+	}
+}
+
+// depChanged records an upgrade or downgrade of shard from mode from to
+// mode to, checking that an upgrade does not wait for the goroutine's own
+// read lock.
+func depChanged(lock *ShardedRWLock, shard uint32, from, to holdMode) {
+	n := depNode{lock.depID, shard}
+	stack := captureStack(1)
+	defer depFlush()
+	lockdep.mu.Lock()
+	defer lockdep.mu.Unlock()
+	if to == holdWrite {
+		held := lockdep.held[goid()]
+		for i := range held {
+			if held[i].node == n && held[i].mode == holdRead {
+				depReport("upgrade of "+n.String()+", also read-locked by this goroutine", &stack, &held[i])
+			}
+		}
+	}
+	if h := depFind(lock, shard, from); h != nil {
+		lockdep.held[h.id][h.i].mode = to
+	}
+}
+
+// depLocation locates a held shard.
+type depLocation struct {
+	id int64
+	i  int
+}
+
+// depFind finds shard held in mode, preferably by the current goroutine.
+// lockdep.mu must be held.
+func depFind(lock *ShardedRWLock, shard uint32, mode holdMode) *depLocation {
+	n := depNode{lock.depID, shard}
+	find := func(id int64) *depLocation {
+		held := lockdep.held[id]
+		for i := len(held) - 1; i >= 0; i-- {
+			if held[i].node == n && held[i].mode == mode {
+				return &depLocation{id, i}
+			}
+		}
+		return nil
+	}
+	self := goid()
+	if loc := find(self); loc != nil {
+		return loc
+	}
+	for id := range lockdep.held {
+		if id != self {
+			if loc := find(id); loc != nil {
+				return loc
+			}
+		}
+	}
+	return nil
+}
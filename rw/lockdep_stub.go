@@ -0,0 +1,18 @@
+//go:build !cxlockdebug
+// +build !cxlockdebug
+
+package cxlockrw
+
+// Without the cxlockdebug tag the lock-order checks compile to nothing.
+
+// SetDeadlockReporter sets the function that receives the reports of the
+// lock-order checks. Without the cxlockdebug tag there are none, and it does
+// nothing.
+func SetDeadlockReporter(fn func(report string)) {}
+
+func depRegister(*ShardedRWLock)                            {}
+func depClosed(*ShardedRWLock)                              {}
+func depAcquire(*ShardedRWLock, uint32, holdMode)           {}
+func depAcquired(*ShardedRWLock, uint32, holdMode)          {}
+func depReleased(*ShardedRWLock, uint32, holdMode)          {}
+func depChanged(*ShardedRWLock, uint32, holdMode, holdMode) {}
@@ -0,0 +1,151 @@
+//go:build cxlockdebug
+// +build cxlockdebug
+
+package cxlockrw
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+// captureReports collects the lock-order reports until the test ends.
+func captureReports(t *testing.T) func() []string {
+	var (
+		mu      sync.Mutex
+		reports []string
+	)
+	SetDeadlockReporter(func(r string) {
+		mu.Lock()
+		reports = append(reports, r)
+		mu.Unlock()
+	})
+	t.Cleanup(func() { SetDeadlockReporter(nil) })
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), reports...)
+	}
+}
+
+// wantReport checks that exactly one report was made and that it contains
+// want.
+func wantReport(t *testing.T, reports []string, want string) {
+	t.Helper()
+	if len(reports) != 1 || !strings.Contains(reports[0], want) {
+		t.Fatalf("reports = %q, want one containing %q", reports, want)
+	}
+}
+
+func TestLockdepInversion(t *testing.T) {
+	reports := captureReports(t)
+	lock := newTestLock(t, BackendGo, 2)
+	for i := 0; i < 2; i++ {
+		lock.Lock(0)
+		lock.RLock(1)
+		lock.RUnlock(1)
+		lock.Unlock(0)
+	}
+	if r := reports(); len(r) != 0 {
+		t.Fatalf("reports for a consistent order = %q", r)
+	}
+	lock.Lock(1)
+	lock.Lock(0)
+	lock.Unlock(0)
+	lock.Unlock(1)
+	wantReport(t, reports(), "lock order inversion")
+}
+
+// The inversion is found through a shard of another lock in between.
+func TestLockdepInversionPath(t *testing.T) {
+	reports := captureReports(t)
+	a := newTestLock(t, BackendGo, 1)
+	b := newTestLock(t, BackendGo, 1)
+	c := newTestLock(t, BackendGo, 1)
+	a.Lock(0)
+	b.Lock(0)
+	b.Unlock(0)
+	a.Unlock(0)
+	b.Lock(0)
+	c.Lock(0)
+	c.Unlock(0)
+	b.Unlock(0)
+	c.Lock(0)
+	a.Lock(0)
+	a.Unlock(0)
+	c.Unlock(0)
+	wantReport(t, reports(), "lock order inversion")
+}
+
+func TestLockdepRecursiveWrite(t *testing.T) {
+	reports := captureReports(t)
+	lock := newTestLock(t, BackendGo, 1)
+	lock.Lock(0)
+	// The Go backend would wait forever; the report comes before the wait.
+	if err := lock.LockTimeout(0, blocked); err == nil {
+		t.Fatal("recursive LockTimeout succeeded")
+	}
+	lock.Unlock(0)
+	wantReport(t, reports(), "recursive write lock")
+}
+
+func TestLockdepReadThenWrite(t *testing.T) {
+	reports := captureReports(t)
+	lock := newTestLock(t, BackendGo, 1)
+	lock.RLock(0)
+	if err := lock.LockTimeout(0, blocked); err == nil {
+		t.Fatal("LockTimeout under the goroutine's own read lock succeeded")
+	}
+	lock.RUnlock(0)
+	wantReport(t, reports(), "already read-locked by this goroutine")
+}
+
+// The reporter may use the locks, since it runs without the checker's mutex.
+func TestLockdepReporterTakesLocks(t *testing.T) {
+	lock := newTestLock(t, BackendGo, 4)
+	var reports []string
+	SetDeadlockReporter(func(r string) {
+		lock.Lock(3)
+		reports = append(reports, r)
+		lock.Unlock(3)
+	})
+	t.Cleanup(func() { SetDeadlockReporter(nil) })
+	lock.Lock(1)
+	lock.Lock(0)
+	lock.Unlock(0)
+	lock.Unlock(1)
+	lock.Lock(0)
+	lock.Lock(1)
+	lock.Unlock(1)
+	lock.Unlock(0)
+	wantReport(t, reports, "lock order inversion")
+}
+
+func TestLockdepBounded(t *testing.T) {
+	reports := captureReports(t)
+	defer func(n int) { depMaxOrders = n }(depMaxOrders)
+	lockdep.mu.Lock()
+	depMaxOrders = lockdep.orders + 3
+	lockdep.mu.Unlock()
+	lock := newTestLock(t, BackendGo, 8)
+	lock.Lock(0)
+	for shard := uint32(1); shard < 8; shard++ {
+		lock.Lock(shard)
+		lock.Unlock(shard)
+	}
+	lock.Unlock(0)
+	wantReport(t, reports(), "no longer checked")
+
+	lockdep.mu.Lock()
+	before := lockdep.orders
+	lockdep.mu.Unlock()
+	if err := lock.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	lockdep.mu.Lock()
+	after := lockdep.orders
+	lockdep.mu.Unlock()
+	if after != before-3 {
+		t.Errorf("orders after Close = %d, want %d", after, before-3)
+	}
+}
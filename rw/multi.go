@@ -0,0 +1,151 @@
+package cxlockrw
+
+import "sort"
+
+// heldShard is one shard taken by a MultiLock.
+type heldShard struct {
+	shard uint32
+	write bool
+}
+
+// MultiLock holds several shards of a ShardedRWLock at once. It is returned
+// by LockMany, RLockMany, LockManyRW and LockShards, and releases exactly the
+// shards it took.
+type MultiLock struct {
+	lock *ShardedRWLock
+	held []heldShard // ascending by shard
+}
+
+// Shards returns the distinct shard indices held, in acquisition order.
+func (m *MultiLock) Shards() []uint32 {
+	shards := make([]uint32, len(m.held))
+	for i, h := range m.held {
+		shards[i] = h.shard
+	}
+	return shards
+}
+
+// Unlock releases every shard held, in reverse acquisition order.
+// Calling Unlock again does nothing.
+func (m *MultiLock) Unlock() {
+	must(m.UnlockChecked())
+}
+
+// UnlockChecked is like Unlock but returns the first failure instead of
+// panicking. Every shard is released even if an earlier release fails.
+func (m *MultiLock) UnlockChecked() error {
+	held := m.held
+	m.held = nil
+	return m.lock.releaseShards(held)
+}
+
+// LockMany write-locks the shards of all keys. Each shard is locked once, in
+// ascending shard order, so concurrent LockMany calls cannot deadlock each other.
+func (lock *ShardedRWLock) LockMany(keys ...string) *MultiLock {
+	return lock.LockManyRW(keys, nil)
+}
+
+// RLockMany read-locks the shards of all keys. Each shard is locked once, in
+// ascending shard order.
+func (lock *ShardedRWLock) RLockMany(keys ...string) *MultiLock {
+	return lock.LockManyRW(nil, keys)
+}
+
+// LockManyRW write-locks the shards of writeKeys and read-locks the shards of
+// readKeys. A shard needed for both is write-locked.
+func (lock *ShardedRWLock) LockManyRW(writeKeys, readKeys []string) *MultiLock {
+	write := make([]uint32, len(writeKeys))
+	for i, key := range writeKeys {
+		write[i] = lock.ShardOf(key)
+	}
+	read := make([]uint32, len(readKeys))
+	for i, key := range readKeys {
+		read[i] = lock.ShardOf(key)
+	}
+	m, err := lock.LockShards(write, read)
+	must(err)
+	return m
+}
+
+// UnlockMany releases the shards write-locked by LockMany(keys...).
+func (lock *ShardedRWLock) UnlockMany(keys ...string) {
+	must(lock.releaseShards(lock.keyShards(keys, true)))
+}
+
+// RUnlockMany releases the shards read-locked by RLockMany(keys...).
+func (lock *ShardedRWLock) RUnlockMany(keys ...string) {
+	must(lock.releaseShards(lock.keyShards(keys, false)))
+}
+
+// LockShards write-locks the write shards and read-locks the read shards,
+// deduplicated and in ascending order; a shard in both sets is write-locked.
+// If any acquisition fails, the shards already taken are released and the
+// error is returned.
+func (lock *ShardedRWLock) LockShards(write, read []uint32) (*MultiLock, error) {
+	held := make([]heldShard, 0, len(write)+len(read))
+	for _, shard := range write {
+		held = append(held, heldShard{shard: shard, write: true})
+	}
+	for _, shard := range read {
+		held = append(held, heldShard{shard: shard})
+	}
+	held = canonical(held)
+	for i, h := range held {
+		var err error
+		if h.write {
+			err = lock.LockChecked(h.shard)
+		} else {
+			err = lock.RLockChecked(h.shard)
+		}
+		if err != nil {
+			lock.releaseShards(held[:i])
+			return nil, err
+		}
+	}
+	return &MultiLock{lock: lock, held: held}, nil
+}
+
+// keyShards returns the canonical shard set for keys, all in one mode.
+func (lock *ShardedRWLock) keyShards(keys []string, write bool) []heldShard {
+	held := make([]heldShard, len(keys))
+	for i, key := range keys {
+		held[i] = heldShard{shard: lock.ShardOf(key), write: write}
+	}
+	return canonical(held)
+}
+
+// releaseShards releases held in reverse order and returns the first failure.
+func (lock *ShardedRWLock) releaseShards(held []heldShard) error {
+	var first error
+	for i := len(held) - 1; i >= 0; i-- {
+		var err error
+		if held[i].write {
+			err = lock.UnlockChecked(held[i].shard)
+		} else {
+			err = lock.RUnlockChecked(held[i].shard)
+		}
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// canonical sorts held by shard and merges duplicates, keeping write mode
+// when a shard appears in both modes.
+func canonical(held []heldShard) []heldShard {
+	sort.Slice(held, func(i, j int) bool {
+		if held[i].shard != held[j].shard {
+			return held[i].shard < held[j].shard
+		}
+		return held[i].write && !held[j].write
+	})
+	out := held[:0]
+	for _, h := range held {
+		if len(out) > 0 && out[len(out)-1].shard == h.shard {
+			continue
+		}
+		out = append(out, h)
+	}
+	return out
+}
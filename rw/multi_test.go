@@ -0,0 +1,112 @@
+package cxlockrw
+
+import (
+	"runtime"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Keys that share a shard are locked once; with the Go backend a second
+// write lock of the same shard would hang.
+func TestLockManyDuplicates(t *testing.T) {
+	eachBackend(t, func(t *testing.T, b Backend) {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		lock := newTestLock(t, b, 8, WithHasher(constHasher{s: 5}))
+		m := lock.LockMany("a", "b", "a")
+		if got := m.Shards(); !slices.Equal(got, []uint32{5}) {
+			t.Errorf("Shards = %v, want [5]", got)
+		}
+		m.Unlock()
+		m = lock.LockManyRW([]string{"a"}, []string{"b"})
+		if got := m.Shards(); !slices.Equal(got, []uint32{5}) {
+			t.Errorf("LockManyRW Shards = %v, want [5]", got)
+		}
+		read := other(func() bool {
+			ok, _ := lock.TryRLock(5)
+			if ok {
+				lock.RUnlock(5)
+			}
+			return ok
+		})
+		if read {
+			t.Error("TryRLock succeeded on a shard LockManyRW was asked to write")
+		}
+		m.Unlock()
+	})
+}
+
+func TestLockManySorted(t *testing.T) {
+	lock := newTestLock(t, BackendGo, 16)
+	keys := make([]string, 10)
+	for i := range keys {
+		keys[i] = "key-" + strconv.Itoa(i)
+	}
+	m := lock.RLockMany(keys...)
+	defer m.Unlock()
+	shards := m.Shards()
+	if !slices.IsSorted(shards) {
+		t.Errorf("Shards = %v, want ascending order", shards)
+	}
+	if len(slices.Compact(slices.Clone(shards))) != len(shards) {
+		t.Errorf("Shards = %v, want no duplicates", shards)
+	}
+	for _, key := range keys {
+		if _, found := slices.BinarySearch(shards, lock.ShardOf(key)); !found {
+			t.Errorf("shard %d of %q not held", lock.ShardOf(key), key)
+		}
+	}
+}
+
+// Two callers locking the same keys in opposite order must not deadlock.
+func TestLockManyOppositeOrder(t *testing.T) {
+	eachBackend(t, func(t *testing.T, b Backend) {
+		lock := newTestLock(t, b, 8)
+		keys := []string{"a", "b", "c", "d", "e", "f"}
+		reversed := slices.Clone(keys)
+		slices.Reverse(reversed)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			var wg sync.WaitGroup
+			for _, ks := range [][]string{keys, reversed} {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < 1000; i++ {
+						lock.LockMany(ks...).Unlock()
+						runtime.Gosched()
+					}
+				}()
+			}
+			wg.Wait()
+		}()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatal("LockMany with the keys in opposite order deadlocked")
+		}
+	})
+}
+
+func TestMultiLockUnlockTwice(t *testing.T) {
+	eachBackend(t, func(t *testing.T, b Backend) {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		lock := newTestLock(t, b, 4)
+		m := lock.LockMany("a", "b", "c")
+		m.Unlock()
+		if err := m.UnlockChecked(); err != nil {
+			t.Fatalf("second Unlock: %v", err)
+		}
+		for shard := uint32(0); shard < 4; shard++ {
+			if ok, err := lock.TryLock(shard); !ok || err != nil {
+				t.Fatalf("TryLock(%d) after Unlock = %v, %v, want true, nil", shard, ok, err)
+			}
+			lock.Unlock(shard)
+		}
+	})
+}
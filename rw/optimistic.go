@@ -0,0 +1,45 @@
+package cxlockrw
+
+// Every shard has a write sequence that is odd while a writer holds the shard
+// and is bumped whenever a writer acquires or releases it. An optimistic
+// reader samples the sequence, reads without taking the lock, and checks
+// afterwards that the sequence has not moved, so it never writes to shared
+// memory.
+//
+// Reads between OptimisticRead and Validate race with writers. Read shared
+// fields with sync/atomic, or copy them out and use the copies only once
+// Validate has succeeded.
+
+// OptimisticRead returns a stamp for an optimistic read of the given shard.
+// The stamp never validates if a writer holds the shard or the lock is closed.
+func (lock *ShardedRWLock) OptimisticRead(shardnum uint32) uint64 {
+	if lock.closed.Load() {
+		return 1
+	}
+	return lock.shards.seq(shardnum).Load()
+}
+
+// Validate reports whether no writer has held the given shard since
+// OptimisticRead returned stamp. If it returns false, the data read since
+// then may be inconsistent and the read must be retried, typically under RLock.
+func (lock *ShardedRWLock) Validate(shardnum uint32, stamp uint64) bool {
+	if stamp&1 != 0 || lock.closed.Load() {
+		return false
+	}
+	return lock.shards.seq(shardnum).Load() == stamp
+}
+
+// ReadOptimistic runs read once optimistically and, if a writer interfered,
+// again under a read lock. read must tolerate seeing inconsistent data on the
+// first run and must not retain anything it read there.
+func (lock *ShardedRWLock) ReadOptimistic(shardnum uint32, read func()) {
+	if stamp := lock.OptimisticRead(shardnum); stamp&1 == 0 {
+		read()
+		if lock.Validate(shardnum, stamp) {
+			return
+		}
+	}
+	lock.RLock(shardnum)
+	defer lock.RUnlock(shardnum)
+	read()
+}
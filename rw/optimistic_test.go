@@ -0,0 +1,109 @@
+package cxlockrw
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	eachBackend(t, func(t *testing.T, b Backend) {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		lock := newTestLock(t, b, 2)
+
+		stamp := lock.OptimisticRead(0)
+		other(func() bool { lock.RLock(0); lock.RUnlock(0); return true })
+		if !lock.Validate(0, stamp) {
+			t.Error("Validate failed after a read lock")
+		}
+		other(func() bool { lock.Lock(1); lock.Unlock(1); return true })
+		if !lock.Validate(0, stamp) {
+			t.Error("Validate failed after a write lock of another shard")
+		}
+		other(func() bool { lock.Lock(0); lock.Unlock(0); return true })
+		if lock.Validate(0, stamp) {
+			t.Error("Validate succeeded after a concurrent Lock and Unlock")
+		}
+
+		lock.Lock(0)
+		if held := lock.OptimisticRead(0); lock.Validate(0, held) {
+			t.Error("Validate succeeded for a stamp taken under the write lock")
+		}
+		lock.Unlock(0)
+
+		stamp = lock.OptimisticRead(0)
+		lock.Lock(0)
+		lock.Downgrade(0)
+		if lock.Validate(0, stamp) {
+			t.Error("Validate succeeded after a Downgrade")
+		}
+		downgraded := lock.OptimisticRead(0)
+		if !lock.Validate(0, downgraded) {
+			t.Error("Validate failed for a stamp taken under the downgraded read lock")
+		}
+		lock.RUnlock(0)
+		if !lock.Validate(0, downgraded) {
+			t.Error("Validate failed after the downgraded read lock was released")
+		}
+
+		stamp = lock.OptimisticRead(0)
+		if err := lock.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		if lock.Validate(0, stamp) {
+			t.Error("Validate succeeded after Close")
+		}
+	})
+}
+
+// A writer interfering with the optimistic run makes ReadOptimistic read
+// again under the read lock.
+func TestReadOptimisticFallsBack(t *testing.T) {
+	eachBackend(t, func(t *testing.T, b Backend) {
+		lock := newTestLock(t, b, 1)
+		runs := 0
+		lock.ReadOptimistic(0, func() {
+			runs++
+			if runs == 1 {
+				other(func() bool { lock.Lock(0); lock.Unlock(0); return true })
+				return
+			}
+			if ok, _ := tryOther(lock, true); ok {
+				t.Error("the second run of read was not under the read lock")
+			}
+		})
+		if runs != 2 {
+			t.Errorf("read ran %d times, want 2", runs)
+		}
+
+		runs = 0
+		lock.ReadOptimistic(0, func() { runs++ })
+		if runs != 1 {
+			t.Errorf("read ran %d times without a writer, want 1", runs)
+		}
+	})
+}
+
+// With a writer holding the shard, ReadOptimistic skips the optimistic run
+// and waits for the read lock.
+func TestReadOptimisticWaitsForWriter(t *testing.T) {
+	eachBackend(t, func(t *testing.T, b Backend) {
+		lock := newTestLock(t, b, 1)
+		release := make(chan struct{})
+		<-acquireAsync(lock, 0, true, release)
+		read := make(chan struct{})
+		runs := 0
+		go func() {
+			lock.ReadOptimistic(0, func() { runs++ })
+			close(read)
+		}()
+		if acquiredWithin(read) {
+			t.Fatal("ReadOptimistic returned while a writer held the shard")
+		}
+		close(release)
+		<-read
+		if runs != 1 {
+			t.Errorf("read ran %d times, want 1", runs)
+		}
+	})
+}
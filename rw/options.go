@@ -0,0 +1,175 @@
+package cxlockrw
+
+import "time"
+
+// Option configures a ShardedRWLock.
+type Option func(*config)
+
+// config holds the settings collected from Options.
+type config struct {
+	hasher  Hasher
+	backend Backend
+	policy  Policy
+
+	cacheLine  int
+	leakCheck  bool
+	bigReader  bool
+	stats      bool
+	hotWindow  time.Duration
+	profile    bool
+	ownerCheck bool
+}
+
+// newConfig applies opts over the default settings.
+func newConfig(opts []Option) config {
+	cfg := config{
+		hasher:    FNV1a{},
+		cacheLine: defaultCacheLine(),
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// WithHasher sets the Hasher used by the key-based methods.
+// The default is FNV1a.
+func WithHasher(h Hasher) Option {
+	return func(cfg *config) {
+		if h != nil {
+			cfg.hasher = h
+		}
+	}
+}
+
+// WithBackend selects the lock implementation. The default, BackendAuto,
+// uses pthread locks when built with cgo and the pure-Go locks otherwise.
+// Building with the purego tag removes the pthread backend entirely.
+func WithBackend(b Backend) Option {
+	return func(cfg *config) {
+		cfg.backend = b
+	}
+}
+
+// Policy decides who gets a shard first when readers and writers compete.
+type Policy int
+
+const (
+	// PolicyReaderPreferred admits a reader whenever no writer holds the
+	// shard, even if writers are waiting. Writers can starve under a steady
+	// stream of readers. This is glibc's default and the default here.
+	PolicyReaderPreferred Policy = iota
+	// PolicyWriterPreferred makes new readers wait while a writer is waiting.
+	// The pthread backend uses PTHREAD_RWLOCK_PREFER_WRITER_NONRECURSIVE_NP,
+	// which is only available with glibc; there a goroutine that already
+	// holds a read lock must not read-lock the same shard again.
+	PolicyWriterPreferred
+	// PolicyFIFO grants the shard in arrival order, letting consecutive
+	// readers in together. It is only available with BackendGo.
+	PolicyFIFO
+)
+
+// String returns the policy name.
+func (p Policy) String() string {
+	switch p {
+	case PolicyReaderPreferred:
+		return "reader-preferred"
+	case PolicyWriterPreferred:
+		return "writer-preferred"
+	case PolicyFIFO:
+		return "fifo"
+	}
+	return "unknown"
+}
+
+// WithPolicy sets the fairness policy of every shard.
+// The default is PolicyReaderPreferred.
+func WithPolicy(p Policy) Option {
+	return func(cfg *config) {
+		cfg.policy = p
+	}
+}
+
+// WithLeakCheck logs a warning, with the stack that created the lock, when a
+// ShardedRWLock is garbage collected without being closed.
+func WithLeakCheck() Option {
+	return func(cfg *config) {
+		cfg.leakCheck = true
+	}
+}
+
+// WithPerCPUReaders makes readers register in one of several per-shard
+// reader slots, one per P up to 64, instead of in the shard's lock. A reader
+// uses the slot of the P it runs on. Reads of
+// a single hot shard then scale with the number of CPUs, while writers become
+// more expensive: they wait for every slot of the shard to empty, and new
+// readers wait for writers, whatever the policy. It is not available for
+// shared locks.
+//
+// In this mode a read unlock of a shard that is not read-locked is not
+// detected and makes the next writer on that shard wait forever.
+func WithPerCPUReaders() Option {
+	return func(cfg *config) {
+		cfg.bigReader = true
+	}
+}
+
+// WithStats records per-shard acquisition counts, contention and wait and
+// hold time histograms, available from ShardedRWLock.Stats. Every
+// acquisition then tries the shard before waiting for it and reads the clock
+// a few times. Without it, statistics cost a nil check per operation.
+func WithStats() Option {
+	return func(cfg *config) {
+		cfg.stats = true
+	}
+}
+
+// WithHotSpots tracks the busiest shards, and a sample of the keys locked
+// through the key-based methods, over a sliding window of the given length,
+// available from ShardedRWLock.HotSpots. It implies WithStats.
+func WithHotSpots(window time.Duration) Option {
+	return func(cfg *config) {
+		cfg.stats = true
+		cfg.hotWindow = window
+	}
+}
+
+// WithContentionProfile reports contended waits to Go's tooling: each wait
+// is added, with the stack that waited, to the profile written by
+// WriteContentionProfile, and while an execution trace is running it is
+// marked as a region, logged as an event when it lasts a millisecond or
+// more. It implies WithStats.
+func WithContentionProfile() Option {
+	return func(cfg *config) {
+		cfg.stats = true
+		cfg.profile = true
+	}
+}
+
+// WithOwnerCheck records which goroutines hold each shard and in which
+// mode, and rejects releases that do not match: unlocking a shard nobody
+// holds (ErrNotHeld), one held only by other goroutines (ErrNotOwner), or
+// one held in another mode, such as RUnlock after Lock (ErrWrongMode). The
+// release fails before the shard's lock is touched; the Checked methods
+// return the error and the others panic with it. Every lock and unlock then
+// also reads the goroutine's id and takes a per-shard mutex.
+//
+// With the check, read locks and the Go backend's write locks must be
+// released by the goroutine that took them. An acquisition that would wait
+// for the caller itself, such as Lock after Lock or after RLock by the same
+// goroutine, fails with ErrDeadlock on every backend instead of hanging.
+func WithOwnerCheck() Option {
+	return func(cfg *config) {
+		cfg.ownerCheck = true
+	}
+}
+
+// WithCacheLine sets the cache line size that each shard is padded and
+// aligned to, so that neighbouring shards never share a line. It must be a
+// power of two from 64 to 512. The default is 64, 128 on arm64 and ppc64, or
+// 256 on s390x.
+func WithCacheLine(size int) Option {
+	return func(cfg *config) {
+		cfg.cacheLine = size
+	}
+}
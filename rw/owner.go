@@ -0,0 +1,233 @@
+package cxlockrw
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+// Errors reported by locks created with WithOwnerCheck, wrapped in a
+// *LockError with a description of who holds the shard.
+var (
+	// ErrNotOwner means the caller released a shard that other goroutines hold.
+	ErrNotOwner = errors.New("shard is held by another goroutine")
+	// ErrWrongMode means the caller released a shard it holds in another mode,
+	// such as RUnlock after Lock.
+	ErrWrongMode = errors.New("shard is held in another mode")
+)
+
+// holdMode is the mode a shard is held in.
+type holdMode uint8
+
+const (
+	holdRead holdMode = iota
+	holdWrite
+	holdUpgradable
+)
+
+// String returns the mode as used in messages, such as "write".
+func (m holdMode) String() string {
+	switch m {
+	case holdWrite:
+		return "write"
+	case holdUpgradable:
+		return "upgradable read"
+	}
+	return "read"
+}
+
+// goid returns the current goroutine's id.
+func goid() int64 {
+	var buf [64]byte
+	return parseGoid(buf[:runtime.Stack(buf[:], false)])
+}
+
+// parseGoid returns the goroutine id in a stack trace that starts like
+// "goroutine 18 [running]:", or 0 if it does not.
+func parseGoid(b []byte) int64 {
+	b, ok := bytes.CutPrefix(b, []byte("goroutine "))
+	if !ok {
+		return 0
+	}
+	if i := bytes.IndexByte(b, ' '); i >= 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseInt(string(b), 10, 64)
+	return id
+}
+
+// ownerTable records which goroutines hold each shard, for WithOwnerCheck.
+type ownerTable struct {
+	shards []ownerShard // shard i is at i*step
+	step   int
+	// readers holds the plain read locks of each shard by goroutine. It is
+	// kept apart so that ownerShard stays pointer-free for alignedSlice;
+	// readers[i] is guarded by shard i's mu.
+	readers []map[int64]int32
+}
+
+// ownerShard is the part of a shard's owners that every check touches,
+// padded to the cache line.
+type ownerShard struct {
+	ownerShardData
+	_ [(minCacheLine - unsafe.Sizeof(ownerShardData{})%minCacheLine) % minCacheLine]byte
+}
+
+type ownerShardData struct {
+	mu       sync.Mutex
+	writer   int64 // goroutine holding the write lock, or 0
+	upgrader int64 // goroutine holding the upgradable read lock, or 0
+}
+
+func newOwnerTable(numShards, line int) *ownerTable {
+	t := &ownerTable{readers: make([]map[int64]int32, numShards)}
+	t.shards, t.step = alignedSlice[ownerShard](numShards, line)
+	return t
+}
+
+// shard returns the owners of shard.
+func (t *ownerTable) shard(shard uint32) *ownerShard {
+	return &t.shards[int(shard)*t.step]
+}
+
+// acquired records that goroutine g took shard in mode.
+func (t *ownerTable) acquired(shard uint32, g int64, mode holdMode) {
+	s := t.shard(shard)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch mode {
+	case holdWrite:
+		s.writer = g
+	case holdUpgradable:
+		s.upgrader = g
+	default:
+		if t.readers[shard] == nil {
+			t.readers[shard] = make(map[int64]int32)
+		}
+		t.readers[shard][g]++
+	}
+}
+
+// acquiring fails with ErrDeadlock if goroutine g already holds shard in a
+// way that would keep it from being granted mode: any lock while g writes, a
+// write lock while g reads, or a second upgradable read lock.
+func (t *ownerTable) acquiring(shard uint32, g int64, mode holdMode) error {
+	s := t.shard(shard)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var held holdMode
+	switch {
+	case s.writer == g:
+		held = holdWrite
+	case mode != holdRead && s.upgrader == g:
+		held = holdUpgradable
+	case mode == holdWrite && t.readers[shard][g] > 0:
+		held = holdRead
+	default:
+		return nil
+	}
+	return fmt.Errorf("%w: goroutine %d acquired a %s lock of a shard it holds for %s", ErrDeadlock, g, mode, held)
+}
+
+// release forgets that goroutine g holds shard in mode, failing without
+// changing anything if g does not hold it that way.
+func (t *ownerTable) release(shard uint32, g int64, mode holdMode) error {
+	s := t.shard(shard)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	readers := t.readers[shard]
+	switch {
+	case mode == holdWrite && s.writer == g:
+		s.writer = 0
+	case mode == holdUpgradable && s.upgrader == g:
+		s.upgrader = 0
+	case mode == holdRead && readers[g] > 0:
+		if readers[g]--; readers[g] == 0 {
+			delete(readers, g)
+		}
+	default:
+		return s.misuse(readers, g, mode)
+	}
+	return nil
+}
+
+// misuse describes why goroutine g cannot release s, whose read locks are
+// readers, in mode. s.mu must be held.
+func (s *ownerShard) misuse(readers map[int64]int32, g int64, mode holdMode) error {
+	var held []string
+	mine := false
+	if s.writer != 0 {
+		held = append(held, "write-locked by goroutine "+strconv.FormatInt(s.writer, 10))
+		mine = mine || s.writer == g
+	}
+	if s.upgrader != 0 {
+		held = append(held, "upgradable-read-locked by goroutine "+strconv.FormatInt(s.upgrader, 10))
+		mine = mine || s.upgrader == g
+	}
+	if len(readers) > 0 {
+		ids := make([]int64, 0, len(readers))
+		for id := range readers {
+			ids = append(ids, id)
+			mine = mine || id == g
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		names := make([]string, len(ids))
+		for i, id := range ids {
+			names[i] = strconv.FormatInt(id, 10)
+		}
+		label := "read-locked by goroutine "
+		if len(names) > 1 {
+			label = "read-locked by goroutines "
+		}
+		held = append(held, label+strings.Join(names, ", "))
+	}
+	switch {
+	case len(held) == 0:
+		return fmt.Errorf("%w: goroutine %d released a %s lock of a shard nobody holds", ErrNotHeld, g, mode)
+	case mine:
+		return fmt.Errorf("%w: goroutine %d released a %s lock, but the shard is %s", ErrWrongMode, g, mode, strings.Join(held, " and "))
+	}
+	return fmt.Errorf("%w: goroutine %d released a %s lock, but the shard is %s", ErrNotOwner, g, mode, strings.Join(held, " and "))
+}
+
+// ownerAcquired records the caller as a holder of shard in mode, if the lock
+// checks owners.
+func (lock *ShardedRWLock) ownerAcquired(shard uint32, mode holdMode) {
+	if lock.owners != nil {
+		lock.owners.acquired(shard, goid(), mode)
+	}
+}
+
+// ownerAcquire checks, if the lock checks owners, that the caller can wait
+// for shard in mode without waiting for itself.
+func (lock *ShardedRWLock) ownerAcquire(shard uint32, mode holdMode) error {
+	if lock.owners == nil {
+		return nil
+	}
+	return lock.owners.acquiring(shard, goid(), mode)
+}
+
+// ownerRelease checks that the caller holds shard in mode and forgets it,
+// if the lock checks owners. It returns the caller's id for ownerRecord.
+func (lock *ShardedRWLock) ownerRelease(shard uint32, mode holdMode) (int64, error) {
+	if lock.owners == nil {
+		return 0, nil
+	}
+	g := goid()
+	return g, lock.owners.release(shard, g, mode)
+}
+
+// ownerRecord records goroutine g, as returned by ownerRelease, as a holder
+// of shard in mode: again if the release failed, or in the new mode of an
+// upgrade or downgrade.
+func (lock *ShardedRWLock) ownerRecord(shard uint32, g int64, mode holdMode) {
+	if lock.owners != nil {
+		lock.owners.acquired(shard, g, mode)
+	}
+}
@@ -0,0 +1,83 @@
+package cxlockrw
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+	"unsafe"
+)
+
+func TestOwnerCheck(t *testing.T) {
+	eachBackend(t, func(t *testing.T, b Backend) {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		lock := newTestLock(t, b, 2, WithOwnerCheck())
+
+		if err := lock.UnlockChecked(0); !errors.Is(err, ErrNotHeld) {
+			t.Errorf("UnlockChecked of a free shard = %v, want ErrNotHeld", err)
+		}
+		if err := lock.RUnlockChecked(0); !errors.Is(err, ErrNotHeld) {
+			t.Errorf("RUnlockChecked of a free shard = %v, want ErrNotHeld", err)
+		}
+
+		lock.Lock(0)
+		if err := lock.RUnlockChecked(0); !errors.Is(err, ErrWrongMode) {
+			t.Errorf("RUnlockChecked after Lock = %v, want ErrWrongMode", err)
+		}
+		if err := other(func() error { return lock.UnlockChecked(0) }); !errors.Is(err, ErrNotOwner) {
+			t.Errorf("UnlockChecked from another goroutine = %v, want ErrNotOwner", err)
+		}
+		lock.Unlock(0)
+
+		lock.RLock(1)
+		if err := lock.UnlockChecked(1); !errors.Is(err, ErrWrongMode) {
+			t.Errorf("UnlockChecked after RLock = %v, want ErrWrongMode", err)
+		}
+		if err := other(func() error { return lock.RUnlockChecked(1) }); !errors.Is(err, ErrNotOwner) {
+			t.Errorf("RUnlockChecked from another goroutine = %v, want ErrNotOwner", err)
+		}
+		lock.RUnlock(1)
+
+		// The refused releases left both shards free.
+		for shard := uint32(0); shard < 2; shard++ {
+			if ok, err := lock.TryLock(shard); !ok || err != nil {
+				t.Fatalf("TryLock(%d) after the refused releases = %v, %v, want true, nil", shard, ok, err)
+			}
+			lock.Unlock(shard)
+		}
+	})
+}
+
+func TestParseGoid(t *testing.T) {
+	tests := []struct {
+		stack string
+		want  int64
+	}{
+		{"goroutine 1 [running]:\nmain.main()", 1},
+		{"goroutine 123456789 [chan receive, 2 minutes]:\n", 123456789},
+		{"goroutine 7", 7},
+		{"goroutine x [running]:", 0},
+		{"thread 1 [running]:", 0},
+		{"", 0},
+	}
+	for _, tt := range tests {
+		if got := parseGoid([]byte(tt.stack)); got != tt.want {
+			t.Errorf("parseGoid(%q) = %d, want %d", tt.stack, got, tt.want)
+		}
+	}
+	self := goid()
+	if self <= 0 || other(goid) == self {
+		t.Errorf("goid = %d here and %d on another goroutine, want distinct positive ids", self, other(goid))
+	}
+}
+
+func TestOwnerTableAligned(t *testing.T) {
+	for _, line := range []int{64, 128, 256} {
+		tbl := newOwnerTable(5, line)
+		for shard := uint32(0); shard < 5; shard++ {
+			if p := uintptr(unsafe.Pointer(tbl.shard(shard))); p%uintptr(line) != 0 {
+				t.Errorf("line %d: owners of shard %d at %#x, not on a line boundary", line, shard, p)
+			}
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package cxlockrw
+
+import (
+	"runtime"
+	"unsafe"
+)
+
+// minCacheLine is the smallest supported cache line size. Padded per-shard
+// types are sized to a multiple of it.
+const minCacheLine = 64
+
+// defaultCacheLine returns the cache line size assumed on this architecture.
+// arm64 and ppc64 use 128 bytes because their prefetchers pull in line pairs.
+func defaultCacheLine() int {
+	switch runtime.GOARCH {
+	case "arm64", "ppc64", "ppc64le":
+		return 128
+	case "s390x":
+		return 256
+	}
+	return 64
+}
+
+// maxCacheLine is the largest supported cache line size. Go allocates
+// objects of up to 512 bytes aligned to their power-of-two size class.
+const maxCacheLine = 512
+
+// validCacheLine reports whether line is a supported cache line size.
+func validCacheLine(line int) bool {
+	return line >= minCacheLine && line <= maxCacheLine && line&(line-1) == 0
+}
+
+// roundUp rounds n up to a multiple of line, which must be a power of two.
+func roundUp(n, line uintptr) uintptr {
+	return (n + line - 1) &^ (line - 1)
+}
+
+// alignedBytes returns n blocks of stride bytes starting on a line boundary,
+// along with the backing slice that keeps them alive.
+func alignedBytes(n int, stride, line uintptr) (mem []byte, base unsafe.Pointer) {
+	mem = make([]byte, uintptr(n)*stride+line)
+	off := roundUp(uintptr(unsafe.Pointer(&mem[0])), line) - uintptr(unsafe.Pointer(&mem[0]))
+	return mem, unsafe.Pointer(&mem[off])
+}
+
+// alignedSlice allocates n values of T, a pointer-free type padded to a
+// multiple of minCacheLine, so that each value starts on its own line.
+// Value i is at index i*step of the returned slice.
+func alignedSlice[T any](n, line int) (elems []T, step int) {
+	var zero T
+	size := unsafe.Sizeof(zero)
+	step = 1
+	if stride := roundUp(size, uintptr(line)); stride%size == 0 {
+		step = int(stride / size)
+	}
+	all := make([]T, (n+1)*step)
+	// Pointer-free allocations of a multiple of 64 bytes start on a 64-byte
+	// boundary; skip whole values to reach a line boundary when it is larger.
+	for i := 0; i < step; i++ {
+		if uintptr(unsafe.Pointer(&all[i]))%uintptr(line) == 0 {
+			return all[i : i+n*step], step
+		}
+	}
+	return all[:n*step], step
+}
+
+// alignedNew allocates a T, a type padded to a multiple of minCacheLine, on
+// its own cache line. Unlike alignedSlice it works for types with pointers,
+// whose large allocations carry a header that breaks alignment.
+func alignedNew[T any](line int) *T {
+	var zero T
+	n := line / int(unsafe.Sizeof(zero))
+	if n < 1 {
+		n = 1
+	}
+	// A block of at most maxCacheLine bytes is allocated from a power-of-two
+	// size class and is therefore aligned to its size.
+	block := make([]T, n)
+	return &block[0]
+}
@@ -0,0 +1,74 @@
+package cxlockrw
+
+import (
+	"runtime"
+	"sync/atomic"
+	"testing"
+)
+
+// benchShards is the part of a backend the padding benchmarks use.
+type benchShards interface {
+	lock(shard uint32) error
+	unlock(shard uint32) error
+	rlock(shard uint32) error
+	runlock(shard uint32) error
+}
+
+// packedShards returns numShards shards of a backend laid out back to back,
+// as they were before shards were padded, so neighbours share cache lines.
+// Backends register themselves from their own test files.
+var packedShards = map[Backend]func(tb testing.TB, numShards int) benchShards{
+	BackendGo: func(tb testing.TB, numShards int) benchShards {
+		return make(packedGoShards, numShards)
+	},
+}
+
+type packedGoShards []goRWLock
+
+func (s packedGoShards) lock(shard uint32) error    { s[shard].lock(); return nil }
+func (s packedGoShards) unlock(shard uint32) error  { return s[shard].unlock() }
+func (s packedGoShards) rlock(shard uint32) error   { s[shard].rlock(); return nil }
+func (s packedGoShards) runlock(shard uint32) error { return s[shard].runlock() }
+
+// BenchmarkShardsParallel locks adjacent shards from parallel goroutines, each
+// on a shard of its own, so that the shards never contend and any slowdown
+// comes from neighbouring shards sharing a cache line. Compare the padded
+// runs with the packed ones on a machine with several cores.
+func BenchmarkShardsParallel(b *testing.B) {
+	numShards := runtime.GOMAXPROCS(0)
+	for _, kind := range backends() {
+		layouts := []struct {
+			name   string
+			shards func(b *testing.B) benchShards
+		}{
+			{"packed", func(b *testing.B) benchShards { return packedShards[kind](b, numShards) }},
+			{"padded", func(b *testing.B) benchShards {
+				return newTestLock(b, kind, numShards).shards
+			}},
+		}
+		for _, layout := range layouts {
+			for _, write := range []bool{true, false} {
+				mode := "read"
+				if write {
+					mode = "write"
+				}
+				b.Run(kind.String()+"/"+layout.name+"/"+mode, func(b *testing.B) {
+					shards := layout.shards(b)
+					var next atomic.Uint32
+					b.RunParallel(func(pb *testing.PB) {
+						shard := (next.Add(1) - 1) % uint32(numShards)
+						for pb.Next() {
+							if write {
+								shards.lock(shard)
+								shards.unlock(shard)
+							} else {
+								shards.rlock(shard)
+								shards.runlock(shard)
+							}
+						}
+					})
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package cxlockrw
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/trace"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Contended waits are invisible to Go's mutex and block profiles when the
+// shards are pthread locks, because the waiting happens inside cgo. Locks
+// created with WithContentionProfile record them in a process-wide
+// contention profile instead, and mark them in execution traces.
+//
+// Waits of the pure-Go backend already show up in the block profile, as
+// select statements in goRWLock.wait, once runtime.SetBlockProfileRate is
+// set.
+
+// traceLongWait is the wait after which a contended acquisition is logged
+// as a trace event.
+const traceLongWait = time.Millisecond
+
+// maxContentionDepth is the number of stack frames kept per sample.
+const maxContentionDepth = 32
+
+// contentionProfile aggregates contended waits by stack.
+var contentionProfile struct {
+	mu      sync.Mutex
+	samples map[[maxContentionDepth]uintptr]*contentionSample
+}
+
+type contentionSample struct {
+	count int64
+	delay int64 // nanoseconds
+}
+
+// recordContention adds a wait of d to the sample for the caller's stack.
+// skip is the number of frames to leave out above recordContention's caller.
+func recordContention(d time.Duration, skip int) {
+	var stk [maxContentionDepth]uintptr
+	runtime.Callers(skip+2, stk[:])
+	p := &contentionProfile
+	p.mu.Lock()
+	if p.samples == nil {
+		p.samples = make(map[[maxContentionDepth]uintptr]*contentionSample)
+	}
+	s := p.samples[stk]
+	if s == nil {
+		s = &contentionSample{}
+		p.samples[stk] = s
+	}
+	s.count++
+	s.delay += int64(d)
+	p.mu.Unlock()
+}
+
+// waitTraced waits for a shard through wait, marking the wait as a
+// runtime/trace region and logging it if it takes longer than
+// traceLongWait.
+func waitTraced(ctx context.Context, shard uint32, write bool, wait func() error) error {
+	if !trace.IsEnabled() {
+		return wait()
+	}
+	mode := "read"
+	if write {
+		mode = "write"
+	}
+	start := time.Now()
+	region := trace.StartRegion(ctx, "cxlockrw."+mode+"Wait")
+	err := wait()
+	region.End()
+	if d := time.Since(start); d >= traceLongWait {
+		trace.Logf(ctx, "cxlockrw", "shard %d %s wait %v", shard, mode, d)
+	}
+	return err
+}
+
+// WriteContentionProfile writes the contended waits recorded by locks
+// created with WithContentionProfile, in the legacy text format of Go's
+// contention profiles: one line per stack with the total delay in
+// nanoseconds, the number of waits and the stack's program counters,
+// followed by the symbolized frames as comments. go tool pprof reads it
+// like a mutex profile.
+//
+// The profile covers the whole process since it started: it is never reset,
+// and it keeps one entry for every distinct stack that has waited, so it
+// grows with the number of contended call sites.
+func WriteContentionProfile(w io.Writer) error {
+	p := &contentionProfile
+	type entry struct {
+		stk []uintptr
+		contentionSample
+	}
+	p.mu.Lock()
+	entries := make([]entry, 0, len(p.samples))
+	for stk, s := range p.samples {
+		n := 0
+		for n < len(stk) && stk[n] != 0 {
+			n++
+		}
+		entries = append(entries, entry{stk: append([]uintptr(nil), stk[:n]...), contentionSample: *s})
+	}
+	p.mu.Unlock()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].delay > entries[j].delay })
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "--- contention:\ncycles/second=%d\nsampling period=1\n", int64(time.Second))
+	for _, e := range entries {
+		fmt.Fprintf(bw, "%d %d @", e.delay, e.count)
+		for _, pc := range e.stk {
+			fmt.Fprintf(bw, " %#x", pc)
+		}
+		fmt.Fprintln(bw)
+		frames := runtime.CallersFrames(e.stk)
+		for {
+			frame, more := frames.Next()
+			fmt.Fprintf(bw, "#\t%#x\t%s+%#x\t%s:%d\n", frame.PC, frame.Function, frame.PC-frame.Entry, frame.File, frame.Line)
+			if !more {
+				break
+			}
+		}
+		fmt.Fprintln(bw)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,115 @@
+package cxlockrw
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// contendedProfile makes a writer wait for a shard of a lock with
+// WithContentionProfile and returns the profile written afterwards.
+func contendedProfile(t *testing.T) []byte {
+	t.Helper()
+	lock := newTestLock(t, BackendGo, 1, WithContentionProfile())
+	lock.Lock(0)
+	waited := waitingWriter(lock, 0)
+	lock.Unlock(0)
+	if err := <-waited; err != nil {
+		t.Fatalf("waiting writer: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := WriteContentionProfile(&buf); err != nil {
+		t.Fatalf("WriteContentionProfile: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// writerSample returns the delay and count of the waiting writer's sample in
+// prof, checking the format on the way.
+func writerSample(t *testing.T, prof []byte) (delay, count int64) {
+	t.Helper()
+	lines := strings.Split(string(prof), "\n")
+	if len(lines) < 3 || lines[0] != "--- contention:" || lines[1] != "cycles/second=1000000000" || lines[2] != "sampling period=1" {
+		t.Fatalf("profile header = %q, want the legacy contention header", lines[:min(3, len(lines))])
+	}
+	// Each sample is a "delay count @ pcs" line followed by its frames as
+	// comments.
+	for i := 3; i < len(lines); i++ {
+		fields := strings.Fields(lines[i])
+		if len(fields) < 4 || fields[2] != "@" {
+			continue
+		}
+		var frames []string
+		for j := i + 1; j < len(lines) && strings.HasPrefix(lines[j], "#"); j++ {
+			frames = append(frames, lines[j])
+		}
+		if len(frames) != len(fields)-3 {
+			t.Errorf("sample %q has %d frames, want one per pc", lines[i], len(frames))
+		}
+		if !strings.Contains(strings.Join(frames, "\n"), "waitingWriter") {
+			continue
+		}
+		delay, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			t.Fatalf("delay %q: %v", fields[0], err)
+		}
+		count, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			t.Fatalf("count %q: %v", fields[1], err)
+		}
+		return delay, count
+	}
+	t.Fatal("no sample for the waiting writer in the profile")
+	return 0, 0
+}
+
+func TestWriteContentionProfile(t *testing.T) {
+	delay, count := writerSample(t, contendedProfile(t))
+	if time.Duration(delay) < blocked {
+		t.Errorf("delay of the waiting writer = %v, want at least %v", time.Duration(delay), blocked)
+	}
+	if count < 1 {
+		t.Errorf("count of the waiting writer = %d, want at least 1", count)
+	}
+}
+
+// go tool pprof must read the same samples from the profile.
+func TestContentionProfilePprof(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs go tool pprof")
+	}
+	gotool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	prof := contendedProfile(t)
+	delay, count := writerSample(t, prof)
+	path := filepath.Join(t.TempDir(), "contention.prof")
+	if err := os.WriteFile(path, prof, 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Test binaries have no symbol table to symbolize with, so compare the
+	// raw samples.
+	out, err := exec.Command(gotool, "tool", "pprof", "-raw", "-symbolize=none", path).CombinedOutput()
+	if err != nil {
+		t.Fatalf("go tool pprof: %v\n%s", err, out)
+	}
+	if !bytes.Contains(out, []byte("contentions/count delay/nanoseconds")) {
+		t.Errorf("go tool pprof did not read a contention profile:\n%s", out)
+	}
+	sample := strconv.FormatInt(count, 10) + " " + strconv.FormatInt(delay, 10) + ":"
+	found := false
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(strings.Join(strings.Fields(line), " "), sample) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("go tool pprof shows no sample %q:\n%s", sample, out)
+	}
+}
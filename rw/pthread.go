@@ -0,0 +1,589 @@
+//go:build cgo && !purego
+// +build cgo,!purego
+
+package cxlockrw
+
+/*
+#cgo LDFLAGS: -lpthread
+#define _GNU_SOURCE
+#include <pthread.h>
+#include <stdlib.h>
+#include <errno.h>
+#include <time.h>
+
+// Each shard pairs a read-write lock with an upgrade mutex. Writers and
+// upgradable readers hold the mutex as well as the read-write lock, so a
+// shard can move between read and write mode without letting another
+// writer in.
+
+// Initializes a shard; returns the pthread result code. pshared makes it
+// usable from any process that maps its memory, and prefer_writer selects
+// the writer-preferring kind where glibc supports it.
+int rwlock_init(pthread_rwlock_t *lock, pthread_mutex_t *up, int pshared, int prefer_writer) {
+    pthread_rwlockattr_t attr;
+    pthread_mutexattr_t mattr;
+    int rc = pthread_rwlockattr_init(&attr);
+    if (rc != 0) {
+        return rc;
+    }
+    if (pshared) {
+        rc = pthread_rwlockattr_setpshared(&attr, PTHREAD_PROCESS_SHARED);
+    }
+    if (rc == 0 && prefer_writer) {
+#if defined(__GLIBC__)
+        rc = pthread_rwlockattr_setkind_np(&attr, PTHREAD_RWLOCK_PREFER_WRITER_NONRECURSIVE_NP);
+#else
+        rc = ENOTSUP;
+#endif
+    }
+    if (rc == 0) {
+        rc = pthread_rwlock_init(lock, &attr);
+    }
+    pthread_rwlockattr_destroy(&attr);
+    if (rc != 0) {
+        return rc;
+    }
+
+    // An error-checking mutex reports a recursive write lock as EDEADLK
+    // instead of hanging.
+    rc = pthread_mutexattr_init(&mattr);
+    if (rc == 0) {
+        rc = pthread_mutexattr_settype(&mattr, PTHREAD_MUTEX_ERRORCHECK);
+        if (rc == 0 && pshared) {
+            rc = pthread_mutexattr_setpshared(&mattr, PTHREAD_PROCESS_SHARED);
+        }
+        if (rc == 0) {
+            rc = pthread_mutex_init(up, &mattr);
+        }
+        pthread_mutexattr_destroy(&mattr);
+    }
+    if (rc != 0) {
+        pthread_rwlock_destroy(lock);
+    }
+    return rc;
+}
+
+// Destroys a shard; returns the pthread result code.
+int rwlock_destroy(pthread_rwlock_t *lock, pthread_mutex_t *up) {
+    int rc = pthread_rwlock_destroy(lock);
+    int mrc = pthread_mutex_destroy(up);
+    return rc != 0 ? rc : mrc;
+}
+
+// Acquires a read lock on a pthread read-write lock; returns the pthread result code.
+int rwlock_rlock(pthread_rwlock_t *lock) {
+    return pthread_rwlock_rdlock(lock);
+}
+
+// Releases a read lock on a pthread read-write lock; returns the pthread result code.
+int rwlock_runlock(pthread_rwlock_t *lock) {
+    return pthread_rwlock_unlock(lock);
+}
+
+// Tries to acquire a read lock without blocking; returns the pthread result code.
+int rwlock_tryrlock(pthread_rwlock_t *lock) {
+    return pthread_rwlock_tryrdlock(lock);
+}
+
+// Acquires a write lock on a shard; returns the pthread result code.
+int rwlock_lock(pthread_rwlock_t *lock, pthread_mutex_t *up) {
+    int rc = pthread_mutex_lock(up);
+    if (rc != 0) {
+        return rc;
+    }
+    rc = pthread_rwlock_wrlock(lock);
+    if (rc != 0) {
+        pthread_mutex_unlock(up);
+    }
+    return rc;
+}
+
+// Tries to acquire a write lock without blocking; returns the pthread result code.
+int rwlock_trylock(pthread_rwlock_t *lock, pthread_mutex_t *up) {
+    int rc = pthread_mutex_trylock(up);
+    if (rc != 0) {
+        return rc;
+    }
+    rc = pthread_rwlock_trywrlock(lock);
+    if (rc != 0) {
+        pthread_mutex_unlock(up);
+    }
+    return rc;
+}
+
+// Releases a write lock on a shard; returns the pthread result code. The
+// error-checking upgrade mutex goes first: it fails with EPERM, changing
+// nothing, when the caller's thread does not own the shard, whereas glibc
+// would take a foreign pthread_rwlock_unlock for a read unlock. Releasing the
+// mutex early is safe, since any new writer still waits for the rwlock.
+int rwlock_unlock(pthread_rwlock_t *lock, pthread_mutex_t *up) {
+    int rc = pthread_mutex_unlock(up);
+    if (rc != 0) {
+        return rc;
+    }
+    return pthread_rwlock_unlock(lock);
+}
+
+// Acquires an upgradable read lock on a shard; returns the pthread result code.
+int rwlock_ulock(pthread_rwlock_t *lock, pthread_mutex_t *up) {
+    int rc = pthread_mutex_lock(up);
+    if (rc != 0) {
+        return rc;
+    }
+    rc = pthread_rwlock_rdlock(lock);
+    if (rc != 0) {
+        pthread_mutex_unlock(up);
+    }
+    return rc;
+}
+
+// Releases an upgradable read lock on a shard; returns the pthread result
+// code. The mutex goes first, as in rwlock_unlock.
+int rwlock_uunlock(pthread_rwlock_t *lock, pthread_mutex_t *up) {
+    int rc = pthread_mutex_unlock(up);
+    if (rc != 0) {
+        return rc;
+    }
+    return pthread_rwlock_unlock(lock);
+}
+
+// Turns an upgradable read lock into a write lock. Other readers may come
+// and go in between, but no writer can, because the caller keeps the upgrade
+// mutex. On failure the upgradable read lock is restored.
+int rwlock_upgrade(pthread_rwlock_t *lock) {
+    int rc = pthread_rwlock_unlock(lock);
+    if (rc != 0) {
+        return rc;
+    }
+    rc = pthread_rwlock_wrlock(lock);
+    if (rc != 0) {
+        pthread_rwlock_rdlock(lock);
+    }
+    return rc;
+}
+
+// Turns a write lock into a plain read lock. No writer can get in between
+// because the upgrade mutex is released only after the read lock is taken.
+int rwlock_downgrade(pthread_rwlock_t *lock, pthread_mutex_t *up) {
+    int rc = pthread_rwlock_unlock(lock);
+    if (rc != 0) {
+        return rc;
+    }
+    rc = pthread_rwlock_rdlock(lock);
+    if (rc != 0) {
+        pthread_mutex_unlock(up);
+        return rc;
+    }
+    return pthread_mutex_unlock(up);
+}
+
+#if defined(__APPLE__)
+// macOS has no timed rwlock or mutex operations, so poll the try variants
+// until the absolute CLOCK_REALTIME deadline passes.
+static int deadline_passed(long long deadline_ns) {
+    struct timespec now;
+    clock_gettime(CLOCK_REALTIME, &now);
+    return (long long)now.tv_sec * 1000000000LL + now.tv_nsec >= deadline_ns;
+}
+
+static int mutex_timed(pthread_mutex_t *up, long long deadline_ns) {
+    struct timespec pause = {0, 50000};
+    for (;;) {
+        int rc = pthread_mutex_trylock(up);
+        if (rc != EBUSY) {
+            return rc;
+        }
+        if (deadline_passed(deadline_ns)) {
+            return ETIMEDOUT;
+        }
+        nanosleep(&pause, NULL);
+    }
+}
+
+static int rwlock_timed(pthread_rwlock_t *lock, int write, long long deadline_ns) {
+    struct timespec pause = {0, 50000};
+    for (;;) {
+        int rc = write ? pthread_rwlock_trywrlock(lock) : pthread_rwlock_tryrdlock(lock);
+        if (rc != EBUSY) {
+            return rc;
+        }
+        if (deadline_passed(deadline_ns)) {
+            return ETIMEDOUT;
+        }
+        nanosleep(&pause, NULL);
+    }
+}
+#else
+static void to_timespec(long long deadline_ns, struct timespec *ts) {
+    ts->tv_sec = deadline_ns / 1000000000LL;
+    ts->tv_nsec = deadline_ns % 1000000000LL;
+}
+
+static int mutex_timed(pthread_mutex_t *up, long long deadline_ns) {
+    struct timespec ts;
+    to_timespec(deadline_ns, &ts);
+    return pthread_mutex_timedlock(up, &ts);
+}
+
+static int rwlock_timed(pthread_rwlock_t *lock, int write, long long deadline_ns) {
+    struct timespec ts;
+    to_timespec(deadline_ns, &ts);
+    return write ? pthread_rwlock_timedwrlock(lock, &ts) : pthread_rwlock_timedrdlock(lock, &ts);
+}
+#endif
+
+// Releases the caller's write lock on a shard, waits on cond and takes the
+// write lock again. The upgrade mutex serves as the condition variable's
+// mutex: it stays held until pthread_cond_wait releases it atomically, so no
+// writer can signal in between, while readers may enter during the wait.
+int rwlock_cond_wait(pthread_rwlock_t *lock, pthread_mutex_t *up, pthread_cond_t *cond) {
+    int rc = pthread_rwlock_unlock(lock);
+    if (rc != 0) {
+        return rc;
+    }
+    rc = pthread_cond_wait(cond, up);
+    int wrc = pthread_rwlock_wrlock(lock);
+    return rc != 0 ? rc : wrc;
+}
+
+// Acquires a read lock, giving up at the absolute CLOCK_REALTIME deadline.
+int rwlock_timedrlock(pthread_rwlock_t *lock, long long deadline_ns) {
+    return rwlock_timed(lock, 0, deadline_ns);
+}
+
+// Acquires a write lock on a shard, giving up at the absolute CLOCK_REALTIME deadline.
+int rwlock_timedlock(pthread_rwlock_t *lock, pthread_mutex_t *up, long long deadline_ns) {
+    int rc = mutex_timed(up, deadline_ns);
+    if (rc != 0) {
+        return rc;
+    }
+    rc = rwlock_timed(lock, 1, deadline_ns);
+    if (rc != 0) {
+        pthread_mutex_unlock(up);
+    }
+    return rc;
+}
+*/
+import "C"
+import (
+	"context"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// pthreadPollInterval bounds each timed pthread wait so that a context
+// cancelled without a deadline is noticed promptly.
+const pthreadPollInterval = 10 * time.Millisecond
+
+// RWLockShard represents a single shard containing a POSIX read-write lock
+// and the upgrade mutex taken by writers and upgradable readers.
+//
+// pthread write locks and mutexes belong to the OS thread that took them:
+// glibc reports EDEADLK to any other locker on that thread and treats an
+// unlock from another thread as a read unlock. The goroutine holding a write
+// or upgradable lock is therefore wired to its thread until it unlocks, so
+// such a lock must be released by the goroutine that acquired it.
+type RWLockShard struct {
+	rwlock  C.pthread_rwlock_t
+	upgrade C.pthread_mutex_t
+	seq     atomic.Uint64 // write sequence, see ShardedRWLock.OptimisticRead
+}
+
+// errno converts a pthread result code to an error.
+func errno(rc C.int) error {
+	if rc == 0 {
+		return nil
+	}
+	return syscall.Errno(rc)
+}
+
+// init initializes the shard's read-write lock with the given policy,
+// shared between processes if pshared is set.
+func (shard *RWLockShard) init(policy Policy, pshared bool) error {
+	var cPshared, cWriter C.int
+	if pshared {
+		cPshared = 1
+	}
+	switch policy {
+	case PolicyReaderPreferred:
+	case PolicyWriterPreferred:
+		cWriter = 1
+	default:
+		return syscall.ENOTSUP
+	}
+	return errno(C.rwlock_init(&shard.rwlock, &shard.upgrade, cPshared, cWriter))
+}
+
+// destroy destroys the shard's read-write lock.
+func (shard *RWLockShard) destroy() error {
+	return errno(C.rwlock_destroy(&shard.rwlock, &shard.upgrade))
+}
+
+// rlock acquires a read lock for the shard.
+func (shard *RWLockShard) rlock() error {
+	return errno(C.rwlock_rlock(&shard.rwlock))
+}
+
+// runlock releases a read lock for the shard.
+func (shard *RWLockShard) runlock() error {
+	return errno(C.rwlock_runlock(&shard.rwlock))
+}
+
+// tryrlock attempts to acquire a read lock for the shard without blocking.
+func (shard *RWLockShard) tryrlock() (bool, error) {
+	return tryResult(C.rwlock_tryrlock(&shard.rwlock))
+}
+
+// lock acquires a write lock for the shard.
+func (shard *RWLockShard) lock() error {
+	runtime.LockOSThread()
+	if rc := C.rwlock_lock(&shard.rwlock, &shard.upgrade); rc != 0 {
+		runtime.UnlockOSThread()
+		return syscall.Errno(rc)
+	}
+	return nil
+}
+
+// unlock releases a write lock for the shard.
+func (shard *RWLockShard) unlock() error {
+	if rc := C.rwlock_unlock(&shard.rwlock, &shard.upgrade); rc != 0 {
+		return syscall.Errno(rc)
+	}
+	runtime.UnlockOSThread()
+	return nil
+}
+
+// trylock attempts to acquire a write lock for the shard without blocking.
+func (shard *RWLockShard) trylock() (bool, error) {
+	runtime.LockOSThread()
+	ok, err := tryResult(C.rwlock_trylock(&shard.rwlock, &shard.upgrade))
+	if !ok {
+		runtime.UnlockOSThread()
+	}
+	return ok, err
+}
+
+// ulock acquires an upgradable read lock for the shard.
+func (shard *RWLockShard) ulock() error {
+	runtime.LockOSThread()
+	if rc := C.rwlock_ulock(&shard.rwlock, &shard.upgrade); rc != 0 {
+		runtime.UnlockOSThread()
+		return syscall.Errno(rc)
+	}
+	return nil
+}
+
+// uunlock releases an upgradable read lock for the shard.
+func (shard *RWLockShard) uunlock() error {
+	if rc := C.rwlock_uunlock(&shard.rwlock, &shard.upgrade); rc != 0 {
+		return syscall.Errno(rc)
+	}
+	runtime.UnlockOSThread()
+	return nil
+}
+
+// upgradeLock turns the caller's upgradable read lock into a write lock.
+func (shard *RWLockShard) upgradeLock() error {
+	return errno(C.rwlock_upgrade(&shard.rwlock))
+}
+
+// downgrade turns the caller's write lock into a read lock.
+func (shard *RWLockShard) downgrade() error {
+	if rc := C.rwlock_downgrade(&shard.rwlock, &shard.upgrade); rc != 0 {
+		return syscall.Errno(rc)
+	}
+	runtime.UnlockOSThread()
+	return nil
+}
+
+// pthreadCond is a pthread_cond_t paired with a shard's upgrade mutex.
+type pthreadCond struct {
+	shard *RWLockShard
+	cond  *C.pthread_cond_t
+}
+
+// newPthreadCond initializes a condition variable for shard. It is
+// destroyed when garbage collected.
+func newPthreadCond(shard *RWLockShard) (*pthreadCond, error) {
+	c := &pthreadCond{shard: shard, cond: new(C.pthread_cond_t)}
+	if rc := C.pthread_cond_init(c.cond, nil); rc != 0 {
+		return nil, syscall.Errno(rc)
+	}
+	runtime.SetFinalizer(c, func(c *pthreadCond) { C.pthread_cond_destroy(c.cond) })
+	return c, nil
+}
+
+// wait waits on the condition variable with the shard write-locked. The
+// goroutine stays wired to its thread, which owns the upgrade mutex.
+func (c *pthreadCond) wait() error {
+	err := errno(C.rwlock_cond_wait(&c.shard.rwlock, &c.shard.upgrade, c.cond))
+	runtime.KeepAlive(c)
+	return err
+}
+
+func (c *pthreadCond) signal()    { C.pthread_cond_signal(c.cond); runtime.KeepAlive(c) }
+func (c *pthreadCond) broadcast() { C.pthread_cond_broadcast(c.cond); runtime.KeepAlive(c) }
+
+// tryResult converts a pthread try-lock result code: EBUSY means the lock is
+// held elsewhere and is not an error.
+func tryResult(rc C.int) (bool, error) {
+	switch rc {
+	case 0:
+		return true, nil
+	case C.EBUSY:
+		return false, nil
+	}
+	return false, syscall.Errno(rc)
+}
+
+// rlockContext acquires a read lock for the shard, giving up when ctx is done.
+func (shard *RWLockShard) rlockContext(ctx context.Context) error {
+	return shard.timed(ctx, false)
+}
+
+// lockContext acquires a write lock for the shard, giving up when ctx is done.
+func (shard *RWLockShard) lockContext(ctx context.Context) error {
+	runtime.LockOSThread()
+	err := shard.timed(ctx, true)
+	if err != nil {
+		runtime.UnlockOSThread()
+	}
+	return err
+}
+
+// timed waits for the shard in slices of at most pthreadPollInterval,
+// checking ctx between slices. A slice that times out holds nothing.
+func (shard *RWLockShard) timed(ctx context.Context, write bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if ctx.Done() == nil {
+		if write {
+			return errno(C.rwlock_lock(&shard.rwlock, &shard.upgrade))
+		}
+		return errno(C.rwlock_rlock(&shard.rwlock))
+	}
+	ctxDeadline, hasDeadline := ctx.Deadline()
+	for {
+		deadline := time.Now().Add(pthreadPollInterval)
+		if hasDeadline && ctxDeadline.Before(deadline) {
+			deadline = ctxDeadline
+		}
+		var rc C.int
+		if write {
+			rc = C.rwlock_timedlock(&shard.rwlock, &shard.upgrade, C.longlong(deadline.UnixNano()))
+		} else {
+			rc = C.rwlock_timedrlock(&shard.rwlock, C.longlong(deadline.UnixNano()))
+		}
+		switch rc {
+		case 0:
+			return nil
+		case C.ETIMEDOUT:
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if hasDeadline && !time.Now().Before(ctxDeadline) {
+				return context.DeadlineExceeded
+			}
+		default:
+			return syscall.Errno(rc)
+		}
+	}
+}
+
+// pthreadAvailable reports whether the pthread backend is compiled in.
+const pthreadAvailable = true
+
+// pthreadBackend stores each shard in a POSIX read-write lock. The shards
+// are stride bytes apart, starting on a cache line boundary, in either Go
+// memory or a mapped file.
+type pthreadBackend struct {
+	mem    []byte
+	base   unsafe.Pointer
+	stride uintptr
+	n      int
+}
+
+// pthreadStride returns the distance between shards padded to line.
+func pthreadStride(line int) uintptr {
+	return roundUp(unsafe.Sizeof(RWLockShard{}), uintptr(line))
+}
+
+// newPthreadBackend initializes numShards pthread read-write locks, each on
+// its own cache line.
+func newPthreadBackend(numShards int, policy Policy, line int) (backend, error) {
+	b := &pthreadBackend{stride: pthreadStride(line), n: numShards}
+	b.mem, b.base = alignedBytes(numShards, b.stride, uintptr(line))
+	if err := b.init(policy, false); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// init initializes every shard, destroying the ones already initialized if
+// one fails.
+func (b *pthreadBackend) init(policy Policy, pshared bool) error {
+	for i := 0; i < b.n; i++ {
+		if err := b.shard(uint32(i)).init(policy, pshared); err != nil {
+			for j := 0; j < i; j++ {
+				b.shard(uint32(j)).destroy()
+			}
+			return &LockError{Op: "init", Shard: uint32(i), Err: err}
+		}
+	}
+	return nil
+}
+
+// shard returns the lock for a shard index.
+func (b *pthreadBackend) shard(shard uint32) *RWLockShard {
+	if int(shard) >= b.n {
+		panic("cxlockrw: shard " + strconv.FormatUint(uint64(shard), 10) + " out of range with " + strconv.Itoa(b.n) + " shards")
+	}
+	return (*RWLockShard)(unsafe.Add(b.base, uintptr(shard)*b.stride))
+}
+
+func (b *pthreadBackend) rlock(shard uint32) error   { return b.shard(shard).rlock() }
+func (b *pthreadBackend) runlock(shard uint32) error { return b.shard(shard).runlock() }
+func (b *pthreadBackend) lock(shard uint32) error    { return b.shard(shard).lock() }
+func (b *pthreadBackend) unlock(shard uint32) error  { return b.shard(shard).unlock() }
+
+func (b *pthreadBackend) ulock(shard uint32) error     { return b.shard(shard).ulock() }
+func (b *pthreadBackend) uunlock(shard uint32) error   { return b.shard(shard).uunlock() }
+func (b *pthreadBackend) upgrade(shard uint32) error   { return b.shard(shard).upgradeLock() }
+func (b *pthreadBackend) downgrade(shard uint32) error { return b.shard(shard).downgrade() }
+
+func (b *pthreadBackend) seq(shard uint32) *atomic.Uint64 { return &b.shard(shard).seq }
+
+func (b *pthreadBackend) newCond(shard uint32) (shardCond, error) {
+	c, err := newPthreadCond(b.shard(shard))
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (b *pthreadBackend) tryRLock(shard uint32) (bool, error) { return b.shard(shard).tryrlock() }
+func (b *pthreadBackend) tryLock(shard uint32) (bool, error)  { return b.shard(shard).trylock() }
+
+func (b *pthreadBackend) rlockContext(ctx context.Context, shard uint32) error {
+	return b.shard(shard).rlockContext(ctx)
+}
+
+func (b *pthreadBackend) lockContext(ctx context.Context, shard uint32) error {
+	return b.shard(shard).lockContext(ctx)
+}
+
+// close destroys every shard's read-write lock and reports the first failure.
+func (b *pthreadBackend) close() error {
+	var first error
+	for i := 0; i < b.n; i++ {
+		if err := b.shard(uint32(i)).destroy(); err != nil && first == nil {
+			first = &LockError{Op: "destroy", Shard: uint32(i), Err: err}
+		}
+	}
+	return first
+}
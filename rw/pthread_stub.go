@@ -0,0 +1,12 @@
+//go:build !cgo || purego
+// +build !cgo purego
+
+package cxlockrw
+
+// pthreadAvailable reports whether the pthread backend is compiled in.
+const pthreadAvailable = false
+
+// newPthreadBackend is never called when cgo is unavailable.
+func newPthreadBackend(numShards int, policy Policy, line int) (backend, error) {
+	panic("cxlockrw: pthread backend not available in this build")
+}
@@ -0,0 +1,93 @@
+//go:build cgo && !purego
+// +build cgo,!purego
+
+package cxlockrw
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func init() {
+	packedShards[BackendPthread] = func(tb testing.TB, numShards int) benchShards {
+		b := &pthreadBackend{stride: unsafe.Sizeof(RWLockShard{}), n: numShards}
+		b.mem, b.base = alignedBytes(numShards, b.stride, minCacheLine)
+		if err := b.init(PolicyReaderPreferred, false); err != nil {
+			tb.Fatalf("init: %v", err)
+		}
+		tb.Cleanup(func() { b.close() })
+		return b
+	}
+}
+
+// onOtherThread runs fn on a goroutine wired to its own OS thread, which
+// cannot be the thread of a goroutine holding a pthread write lock.
+func onOtherThread[T any](fn func() T) T {
+	ch := make(chan T)
+	go func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		ch <- fn()
+	}()
+	return <-ch
+}
+
+// A release from a thread that does not own the shard must fail without
+// disturbing the owner's lock.
+func TestPthreadForeignUnlock(t *testing.T) {
+	tests := []struct {
+		name            string
+		acquire         func(lock *ShardedRWLock) error
+		release         func(lock *ShardedRWLock) error
+		releaseAcquired func(lock *ShardedRWLock)
+	}{
+		{
+			name:            "write",
+			acquire:         func(lock *ShardedRWLock) error { return lock.LockChecked(0) },
+			release:         func(lock *ShardedRWLock) error { return lock.UnlockChecked(0) },
+			releaseAcquired: func(lock *ShardedRWLock) { lock.Unlock(0) },
+		},
+		{
+			name:            "upgradable",
+			acquire:         func(lock *ShardedRWLock) error { return lock.UpgradableRLockChecked(0) },
+			release:         func(lock *ShardedRWLock) error { return lock.UpgradableRUnlockChecked(0) },
+			releaseAcquired: func(lock *ShardedRWLock) { lock.UpgradableRUnlock(0) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lock := newTestLock(t, BackendPthread, 1)
+			if err := tt.acquire(lock); err != nil {
+				t.Fatalf("acquire: %v", err)
+			}
+			err := onOtherThread(func() error { return tt.release(lock) })
+			if !errors.Is(err, ErrNotHeld) {
+				t.Fatalf("release from another thread = %v, want ErrNotHeld", err)
+			}
+			tt.releaseAcquired(lock)
+			if ok, err := lock.TryLock(0); !ok || err != nil {
+				t.Fatalf("TryLock after the owner released = %v, %v, want true, nil", ok, err)
+			}
+			lock.Unlock(0)
+			if err := lock.LockTimeout(0, time.Second); err != nil {
+				t.Fatalf("LockTimeout after the owner released: %v", err)
+			}
+			lock.Unlock(0)
+		})
+	}
+}
+
+// pthread reports a recursive write lock even without WithOwnerCheck.
+func TestPthreadRecursiveLock(t *testing.T) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	lock := newTestLock(t, BackendPthread, 1)
+	lock.Lock(0)
+	if err := lock.LockChecked(0); !errors.Is(err, ErrDeadlock) {
+		t.Errorf("LockChecked after Lock = %v, want ErrDeadlock", err)
+	}
+	lock.Unlock(0)
+}
@@ -0,0 +1,446 @@
+package cxlockrw
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// goRWLock is a read-write lock implemented in Go. With PolicyReaderPreferred
+// it admits readers like glibc's default pthread_rwlock_t: a reader is
+// admitted whenever no writer holds the lock, even if writers are waiting.
+// Unlike the pthread backend it does not know which goroutine holds it, so a
+// recursive write lock hangs, as with sync.RWMutex, instead of failing with
+// ErrDeadlock; WithOwnerCheck catches that for both backends.
+//
+// Blocked goroutines wait in a FIFO queue and are handed the lock directly
+// by the goroutine that releases it.
+//
+// At most one reader at a time may hold the lock in upgradable mode. It counts
+// as a reader, excludes other upgradable readers, and can be promoted to a
+// writer once the other readers have left.
+type goRWLock struct {
+	mu       sync.Mutex
+	policy   Policy
+	readers  int32 // active readers, or -1 while a writer holds the lock
+	writers  int32 // queued writers
+	upgrader bool  // one of the readers holds the lock in upgradable mode
+	upgrade  *goWaiter
+	head     *goWaiter
+	tail     *goWaiter
+}
+
+// goMode is the mode a goWaiter asks for.
+type goMode uint8
+
+const (
+	goRead goMode = iota
+	goWrite
+	goUpgradable
+)
+
+// goWaiter is a goroutine queued on a goRWLock, or the upgrader waiting for
+// the other readers to leave.
+type goWaiter struct {
+	mode       goMode
+	queued     bool
+	ready      chan struct{}
+	prev, next *goWaiter
+}
+
+var goWaiterPool = sync.Pool{
+	New: func() any {
+		return &goWaiter{ready: make(chan struct{}, 1)}
+	},
+}
+
+// rlock acquires a read lock.
+func (l *goRWLock) rlock() {
+	l.mu.Lock()
+	if l.canRead() {
+		l.readers++
+		l.mu.Unlock()
+		return
+	}
+	l.wait(context.Background(), goRead)
+}
+
+// rlockContext acquires a read lock, giving up when ctx is done.
+func (l *goRWLock) rlockContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	if l.canRead() {
+		l.readers++
+		l.mu.Unlock()
+		return nil
+	}
+	return l.wait(ctx, goRead)
+}
+
+// tryRLock acquires a read lock if the policy would admit a reader now.
+func (l *goRWLock) tryRLock() bool {
+	l.mu.Lock()
+	ok := l.canRead()
+	if ok {
+		l.readers++
+	}
+	l.mu.Unlock()
+	return ok
+}
+
+// runlock releases a read lock. Like pthread it fails with EPERM if the
+// lock is not read-locked.
+func (l *goRWLock) runlock() error {
+	l.mu.Lock()
+	if l.readers <= 0 {
+		l.mu.Unlock()
+		return syscall.EPERM
+	}
+	l.readers--
+	switch {
+	case l.readers == 0:
+		l.grant()
+	case l.readers == 1 && l.upgrade != nil:
+		// Only the upgrader is left; promote it.
+		w := l.upgrade
+		l.upgrade = nil
+		l.upgrader = false
+		l.readers = -1
+		w.ready <- struct{}{}
+	}
+	l.mu.Unlock()
+	return nil
+}
+
+// lock acquires a write lock.
+func (l *goRWLock) lock() {
+	l.mu.Lock()
+	if l.readers == 0 && l.head == nil {
+		l.readers = -1
+		l.mu.Unlock()
+		return
+	}
+	l.wait(context.Background(), goWrite)
+}
+
+// lockContext acquires a write lock, giving up when ctx is done.
+func (l *goRWLock) lockContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	if l.readers == 0 && l.head == nil {
+		l.readers = -1
+		l.mu.Unlock()
+		return nil
+	}
+	return l.wait(ctx, goWrite)
+}
+
+// tryLock acquires a write lock if the lock is free.
+func (l *goRWLock) tryLock() bool {
+	l.mu.Lock()
+	ok := l.readers == 0 && l.head == nil
+	if ok {
+		l.readers = -1
+	}
+	l.mu.Unlock()
+	return ok
+}
+
+// unlock releases a write lock. Like pthread it fails with EPERM if the
+// lock is not write-locked.
+func (l *goRWLock) unlock() error {
+	l.mu.Lock()
+	if l.readers != -1 {
+		l.mu.Unlock()
+		return syscall.EPERM
+	}
+	l.readers = 0
+	l.grant()
+	l.mu.Unlock()
+	return nil
+}
+
+// ulock acquires an upgradable read lock.
+func (l *goRWLock) ulock() {
+	l.mu.Lock()
+	if l.canRead() && !l.upgrader {
+		l.readers++
+		l.upgrader = true
+		l.mu.Unlock()
+		return
+	}
+	l.wait(context.Background(), goUpgradable)
+}
+
+// uunlock releases an upgradable read lock. It fails with EPERM if the lock
+// is not held in upgradable mode.
+func (l *goRWLock) uunlock() error {
+	l.mu.Lock()
+	if !l.upgrader || l.upgrade != nil {
+		l.mu.Unlock()
+		return syscall.EPERM
+	}
+	l.upgrader = false
+	l.readers--
+	// Another upgradable reader may be queued even if readers remain.
+	l.grant()
+	l.mu.Unlock()
+	return nil
+}
+
+// upgradeLock turns the caller's upgradable read lock into a write lock,
+// waiting for the other readers to leave. New readers are held back
+// meanwhile, and no writer can get in because the upgrader is still a reader.
+func (l *goRWLock) upgradeLock() error {
+	l.mu.Lock()
+	if !l.upgrader || l.upgrade != nil {
+		l.mu.Unlock()
+		return syscall.EPERM
+	}
+	if l.readers == 1 {
+		l.upgrader = false
+		l.readers = -1
+		l.mu.Unlock()
+		return nil
+	}
+	w := goWaiterPool.Get().(*goWaiter)
+	w.mode = goWrite
+	l.upgrade = w
+	l.mu.Unlock()
+	<-w.ready
+	goWaiterPool.Put(w)
+	return nil
+}
+
+// downgrade turns the caller's write lock into a read lock and admits the
+// waiters the policy lets in alongside it. It fails with EPERM if the lock
+// is not write-locked.
+func (l *goRWLock) downgrade() error {
+	l.mu.Lock()
+	if l.readers != -1 {
+		l.mu.Unlock()
+		return syscall.EPERM
+	}
+	l.readers = 1
+	l.grant()
+	l.mu.Unlock()
+	return nil
+}
+
+// canRead reports whether a new reader may take the lock without queueing.
+// l.mu must be held.
+func (l *goRWLock) canRead() bool {
+	if l.upgrade != nil {
+		return false
+	}
+	switch l.policy {
+	case PolicyWriterPreferred:
+		return l.readers >= 0 && l.writers == 0
+	case PolicyFIFO:
+		return l.readers >= 0 && l.head == nil
+	}
+	return l.readers >= 0
+}
+
+// wait queues the caller and blocks until the lock is handed over or ctx is
+// done. l.mu must be held; it is released before blocking. If the lock is
+// handed over while the caller is giving up, it is released again so that a
+// failed wait never leaves the lock held.
+func (l *goRWLock) wait(ctx context.Context, mode goMode) error {
+	w := goWaiterPool.Get().(*goWaiter)
+	w.mode = mode
+	l.push(w)
+	l.mu.Unlock()
+	select {
+	case <-w.ready:
+		goWaiterPool.Put(w)
+		return nil
+	case <-ctx.Done():
+	}
+	l.mu.Lock()
+	if w.queued {
+		// Leaving the queue may unblock the waiters behind w.
+		l.remove(w)
+		l.grant()
+		l.mu.Unlock()
+		goWaiterPool.Put(w)
+		return ctx.Err()
+	}
+	l.mu.Unlock()
+	<-w.ready
+	goWaiterPool.Put(w)
+	switch mode {
+	case goWrite:
+		l.unlock()
+	case goUpgradable:
+		l.uunlock()
+	default:
+		l.runlock()
+	}
+	return ctx.Err()
+}
+
+// grant hands the lock to queued waiters that the policy lets in now.
+// It may be called in any state. l.mu must be held.
+func (l *goRWLock) grant() {
+	switch l.policy {
+	case PolicyFIFO:
+		for w := l.head; w != nil && l.readers >= 0 && l.upgrade == nil; w = l.head {
+			if w.mode == goWrite && l.readers != 0 || w.mode == goUpgradable && l.upgrader {
+				return
+			}
+			l.admit(w)
+		}
+	case PolicyWriterPreferred:
+		if l.writers > 0 {
+			if l.readers == 0 {
+				l.admit(l.firstWriter())
+			}
+			return
+		}
+		l.admitReaders()
+	default:
+		l.admitReaders()
+		if l.readers == 0 && l.writers > 0 {
+			l.admit(l.firstWriter())
+		}
+	}
+}
+
+// admitReaders admits every queued reader, and the oldest queued upgradable
+// reader if there is no upgrader, unless a writer holds the lock or an
+// upgrade is pending.
+func (l *goRWLock) admitReaders() {
+	if l.readers < 0 || l.upgrade != nil {
+		return
+	}
+	for w := l.head; w != nil; {
+		next := w.next
+		if w.mode == goRead || w.mode == goUpgradable && !l.upgrader {
+			l.admit(w)
+		}
+		w = next
+	}
+}
+
+// firstWriter returns the oldest queued writer.
+func (l *goRWLock) firstWriter() *goWaiter {
+	w := l.head
+	for w.mode != goWrite {
+		w = w.next
+	}
+	return w
+}
+
+// admit dequeues w, gives it the lock and wakes it.
+func (l *goRWLock) admit(w *goWaiter) {
+	l.remove(w)
+	switch w.mode {
+	case goWrite:
+		l.readers = -1
+	case goUpgradable:
+		l.readers++
+		l.upgrader = true
+	default:
+		l.readers++
+	}
+	w.ready <- struct{}{}
+}
+
+// push appends w to the wait queue.
+func (l *goRWLock) push(w *goWaiter) {
+	w.prev, w.next = l.tail, nil
+	w.queued = true
+	if w.mode == goWrite {
+		l.writers++
+	}
+	if l.tail != nil {
+		l.tail.next = w
+	} else {
+		l.head = w
+	}
+	l.tail = w
+}
+
+// remove unlinks w from the wait queue.
+func (l *goRWLock) remove(w *goWaiter) {
+	if w.prev != nil {
+		w.prev.next = w.next
+	} else {
+		l.head = w.next
+	}
+	if w.next != nil {
+		w.next.prev = w.prev
+	} else {
+		l.tail = w.prev
+	}
+	w.prev, w.next = nil, nil
+	w.queued = false
+	if w.mode == goWrite {
+		l.writers--
+	}
+}
+
+// goShard is a goRWLock and its write sequence, padded to a multiple of the
+// cache line. seq comes after the padding so that the struct never ends in a
+// zero-size field, which the compiler would pad past the cache line.
+type goShard struct {
+	goRWLock
+	_   [(minCacheLine - (unsafe.Sizeof(goRWLock{})+8)%minCacheLine) % minCacheLine]byte
+	seq atomic.Uint64
+}
+
+// goBackend stores each shard in a goRWLock.
+type goBackend struct {
+	shards []*goShard
+}
+
+// newGoBackend allocates numShards Go read-write locks, each on its own
+// cache line.
+func newGoBackend(numShards int, policy Policy, line int) backend {
+	b := &goBackend{
+		shards: make([]*goShard, numShards),
+	}
+	for i := range b.shards {
+		b.shards[i] = alignedNew[goShard](line)
+		b.shards[i].policy = policy
+	}
+	return b
+}
+
+// shard returns the lock for a shard index.
+func (b *goBackend) shard(shard uint32) *goRWLock {
+	return &b.shards[shard].goRWLock
+}
+
+func (b *goBackend) rlock(shard uint32) error   { b.shard(shard).rlock(); return nil }
+func (b *goBackend) runlock(shard uint32) error { return b.shard(shard).runlock() }
+func (b *goBackend) lock(shard uint32) error    { b.shard(shard).lock(); return nil }
+func (b *goBackend) unlock(shard uint32) error  { return b.shard(shard).unlock() }
+func (b *goBackend) close() error               { return nil }
+
+func (b *goBackend) ulock(shard uint32) error     { b.shard(shard).ulock(); return nil }
+func (b *goBackend) uunlock(shard uint32) error   { return b.shard(shard).uunlock() }
+func (b *goBackend) upgrade(shard uint32) error   { return b.shard(shard).upgradeLock() }
+func (b *goBackend) downgrade(shard uint32) error { return b.shard(shard).downgrade() }
+
+func (b *goBackend) seq(shard uint32) *atomic.Uint64 { return &b.shards[shard].seq }
+
+func (b *goBackend) newCond(shard uint32) (shardCond, error) { return newGoCond(b, shard), nil }
+
+func (b *goBackend) tryRLock(shard uint32) (bool, error) { return b.shard(shard).tryRLock(), nil }
+func (b *goBackend) tryLock(shard uint32) (bool, error)  { return b.shard(shard).tryLock(), nil }
+
+func (b *goBackend) rlockContext(ctx context.Context, shard uint32) error {
+	return b.shard(shard).rlockContext(ctx)
+}
+
+func (b *goBackend) lockContext(ctx context.Context, shard uint32) error {
+	return b.shard(shard).lockContext(ctx)
+}
@@ -0,0 +1,179 @@
+package cxlockrw
+
+import (
+	"hash/maphash"
+	"reflect"
+	"unsafe"
+)
+
+// ShardedMap is a map split into the shards of a ShardedRWLock, each part
+// guarded by the lock's shard of the same index. Keys are placed with the
+// lock's hasher, so string keys land in lock.ShardOf(key) and integer keys in
+// lock.ShardOfUint64(uint64(key)); other key types are hashed with
+// maphash.Comparable, which is why ShardedMap needs Go 1.24 or later.
+// Holding a shard through the lock's own methods therefore also guards the
+// map entries of the keys in it.
+//
+// The callbacks of Compute and Range run with the key's shard locked and
+// must not call back into the map or lock that shard.
+type ShardedMap[K comparable, V any] struct {
+	lock *ShardedRWLock
+	hash func(K) uint64
+	m    []map[K]V
+}
+
+// NewShardedMap returns an empty map sharded like lock.
+func NewShardedMap[K comparable, V any](lock *ShardedRWLock) *ShardedMap[K, V] {
+	sm := &ShardedMap[K, V]{
+		lock: lock,
+		hash: keyHash[K](lock.hasher),
+		m:    make([]map[K]V, lock.numShards),
+	}
+	for i := range sm.m {
+		sm.m[i] = make(map[K]V)
+	}
+	return sm
+}
+
+// keyHash returns the function that hashes keys of type K with h, treating
+// every string kind as a string and every integer kind as a uint64.
+func keyHash[K comparable](h Hasher) func(K) uint64 {
+	var zero K
+	switch reflect.TypeOf(&zero).Elem().Kind() {
+	case reflect.String:
+		return func(key K) uint64 { return h.HashString(*(*string)(unsafe.Pointer(&key))) }
+	case reflect.Int8:
+		return func(key K) uint64 { return h.HashUint64(uint64(*(*int8)(unsafe.Pointer(&key)))) }
+	case reflect.Int16:
+		return func(key K) uint64 { return h.HashUint64(uint64(*(*int16)(unsafe.Pointer(&key)))) }
+	case reflect.Int32:
+		return func(key K) uint64 { return h.HashUint64(uint64(*(*int32)(unsafe.Pointer(&key)))) }
+	case reflect.Int64:
+		return func(key K) uint64 { return h.HashUint64(uint64(*(*int64)(unsafe.Pointer(&key)))) }
+	case reflect.Int:
+		return func(key K) uint64 { return h.HashUint64(uint64(*(*int)(unsafe.Pointer(&key)))) }
+	case reflect.Uint8:
+		return func(key K) uint64 { return h.HashUint64(uint64(*(*uint8)(unsafe.Pointer(&key)))) }
+	case reflect.Uint16:
+		return func(key K) uint64 { return h.HashUint64(uint64(*(*uint16)(unsafe.Pointer(&key)))) }
+	case reflect.Uint32:
+		return func(key K) uint64 { return h.HashUint64(uint64(*(*uint32)(unsafe.Pointer(&key)))) }
+	case reflect.Uint64:
+		return func(key K) uint64 { return h.HashUint64(*(*uint64)(unsafe.Pointer(&key))) }
+	case reflect.Uint:
+		return func(key K) uint64 { return h.HashUint64(uint64(*(*uint)(unsafe.Pointer(&key)))) }
+	case reflect.Uintptr:
+		return func(key K) uint64 { return h.HashUint64(uint64(*(*uintptr)(unsafe.Pointer(&key)))) }
+	}
+	seed := maphash.MakeSeed()
+	return func(key K) uint64 { return maphash.Comparable(seed, key) }
+}
+
+// Lock returns the ShardedRWLock guarding the map.
+func (sm *ShardedMap[K, V]) Lock() *ShardedRWLock {
+	return sm.lock
+}
+
+// ShardOf returns the shard index that holds key.
+func (sm *ShardedMap[K, V]) ShardOf(key K) uint32 {
+	return sm.lock.shardOf(sm.hash(key))
+}
+
+// Get returns the value stored for key and whether it was present.
+func (sm *ShardedMap[K, V]) Get(key K) (V, bool) {
+	shard := sm.ShardOf(key)
+	sm.lock.RLock(shard)
+	v, ok := sm.m[shard][key]
+	sm.lock.RUnlock(shard)
+	return v, ok
+}
+
+// Set stores value for key.
+func (sm *ShardedMap[K, V]) Set(key K, value V) {
+	shard := sm.ShardOf(key)
+	sm.lock.Lock(shard)
+	sm.m[shard][key] = value
+	sm.lock.Unlock(shard)
+}
+
+// Delete removes key.
+func (sm *ShardedMap[K, V]) Delete(key K) {
+	shard := sm.ShardOf(key)
+	sm.lock.Lock(shard)
+	delete(sm.m[shard], key)
+	sm.lock.Unlock(shard)
+}
+
+// LoadOrStore returns the value stored for key if present. Otherwise it
+// stores value and returns it. loaded reports whether the value was present.
+func (sm *ShardedMap[K, V]) LoadOrStore(key K, value V) (actual V, loaded bool) {
+	shard := sm.ShardOf(key)
+	sm.lock.RLock(shard)
+	actual, loaded = sm.m[shard][key]
+	sm.lock.RUnlock(shard)
+	if loaded {
+		return actual, true
+	}
+	sm.lock.Lock(shard)
+	defer sm.lock.Unlock(shard)
+	if actual, loaded = sm.m[shard][key]; loaded {
+		return actual, true
+	}
+	sm.m[shard][key] = value
+	return value, false
+}
+
+// Compute calls fn with the value stored for key and whether it was present,
+// and stores the value fn returns, or deletes key if fn returns keep false.
+// It returns the new value and whether key is now present. The key's shard
+// is write-locked while fn runs.
+func (sm *ShardedMap[K, V]) Compute(key K, fn func(value V, loaded bool) (newValue V, keep bool)) (V, bool) {
+	shard := sm.ShardOf(key)
+	sm.lock.Lock(shard)
+	defer sm.lock.Unlock(shard)
+	old, loaded := sm.m[shard][key]
+	v, keep := fn(old, loaded)
+	if keep {
+		sm.m[shard][key] = v
+	} else {
+		delete(sm.m[shard], key)
+		var zero V
+		v = zero
+	}
+	return v, keep
+}
+
+// Range calls fn for every entry until fn returns false. Each shard is
+// read-locked while its entries are visited, so Range sees a consistent view
+// of each shard but not of the whole map.
+func (sm *ShardedMap[K, V]) Range(fn func(key K, value V) bool) {
+	for i := range sm.m {
+		if !sm.rangeShard(uint32(i), fn) {
+			return
+		}
+	}
+}
+
+// rangeShard calls fn for the entries of shard and reports whether fn
+// returned true for all of them. The shard is released even if fn panics.
+func (sm *ShardedMap[K, V]) rangeShard(shard uint32, fn func(key K, value V) bool) bool {
+	sm.lock.RLock(shard)
+	defer sm.lock.RUnlock(shard)
+	for k, v := range sm.m[shard] {
+		if !fn(k, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// Len returns the number of entries.
+func (sm *ShardedMap[K, V]) Len() int {
+	n := 0
+	for i := range sm.m {
+		sm.lock.RLock(uint32(i))
+		n += len(sm.m[i])
+		sm.lock.RUnlock(uint32(i))
+	}
+	return n
+}
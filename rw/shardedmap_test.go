@@ -0,0 +1,113 @@
+package cxlockrw
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestShardedMap(t *testing.T) {
+	sm := NewShardedMap[string, int](newTestLock(t, BackendGo, 4))
+	if _, ok := sm.Get("a"); ok {
+		t.Fatal("Get of a missing key found it")
+	}
+	sm.Set("a", 1)
+	sm.Set("b", 2)
+	if v, ok := sm.Get("a"); v != 1 || !ok {
+		t.Fatalf("Get after Set = %d, %v, want 1, true", v, ok)
+	}
+	if v, loaded := sm.LoadOrStore("a", 5); v != 1 || !loaded {
+		t.Fatalf("LoadOrStore of a present key = %d, %v, want 1, true", v, loaded)
+	}
+	if v, loaded := sm.LoadOrStore("c", 3); v != 3 || loaded {
+		t.Fatalf("LoadOrStore of a missing key = %d, %v, want 3, false", v, loaded)
+	}
+	if v, ok := sm.Compute("a", func(v int, loaded bool) (int, bool) { return v + 10, true }); v != 11 || !ok {
+		t.Fatalf("Compute = %d, %v, want 11, true", v, ok)
+	}
+	if v, ok := sm.Compute("d", func(v int, loaded bool) (int, bool) {
+		if loaded {
+			t.Error("Compute of a missing key reported it loaded")
+		}
+		return 4, true
+	}); v != 4 || !ok {
+		t.Fatalf("Compute of a missing key = %d, %v, want 4, true", v, ok)
+	}
+	if v, ok := sm.Compute("b", func(int, bool) (int, bool) { return 0, false }); v != 0 || ok {
+		t.Fatalf("Compute deleting a key = %d, %v, want 0, false", v, ok)
+	}
+	sm.Delete("c")
+	want := map[string]int{"a": 11, "d": 4}
+	got := map[string]int{}
+	sm.Range(func(k string, v int) bool {
+		got[k] = v
+		return true
+	})
+	if len(got) != len(want) || got["a"] != want["a"] || got["d"] != want["d"] {
+		t.Fatalf("Range saw %v, want %v", got, want)
+	}
+	if n := sm.Len(); n != 2 {
+		t.Fatalf("Len = %d, want 2", n)
+	}
+}
+
+// Range must release the shard it is visiting when it stops early or fn
+// panics.
+func TestShardedMapRangeReleases(t *testing.T) {
+	lock := newTestLock(t, BackendGo, 4)
+	sm := NewShardedMap[int, int](lock)
+	for i := 0; i < 100; i++ {
+		sm.Set(i, i)
+	}
+	visited := 0
+	sm.Range(func(int, int) bool {
+		visited++
+		return false
+	})
+	if visited != 1 {
+		t.Errorf("Range visited %d entries after fn returned false, want 1", visited)
+	}
+	func() {
+		defer func() { recover() }()
+		sm.Range(func(int, int) bool { panic("boom") })
+	}()
+	for shard := uint32(0); shard < 4; shard++ {
+		if ok, err := lock.TryLock(shard); !ok || err != nil {
+			t.Fatalf("TryLock(%d) after Range = %v, %v, want true, nil", shard, ok, err)
+		}
+		lock.Unlock(shard)
+	}
+}
+
+// Locking a key through the lock must guard that key's map entry.
+func TestShardedMapSharesShards(t *testing.T) {
+	lock := newTestLock(t, BackendGo, 8)
+	strs := NewShardedMap[string, int](lock)
+	ints := NewShardedMap[uint32, int](lock)
+	for i := 0; i < 100; i++ {
+		k := "key-" + strconv.Itoa(i)
+		if got, want := strs.ShardOf(k), lock.ShardOf(k); got != want {
+			t.Fatalf("ShardOf(%q) = %d, want lock.ShardOf = %d", k, got, want)
+		}
+		if got, want := ints.ShardOf(uint32(i)), lock.ShardOfUint64(uint64(i)); got != want {
+			t.Fatalf("ShardOf(%d) = %d, want lock.ShardOfUint64 = %d", i, got, want)
+		}
+	}
+
+	lock.LockKey("alpha")
+	get := make(chan struct{})
+	go func() {
+		strs.Get("alpha")
+		close(get)
+	}()
+	if acquiredWithin(get) {
+		t.Error("Get ran while LockKey held the key's shard")
+	}
+	lock.UnlockKey("alpha")
+	<-get
+
+	type point struct{ X, Y int }
+	points := NewShardedMap[point, bool](lock)
+	if a, b := points.ShardOf(point{1, 2}), points.ShardOf(point{1, 2}); a != b || a >= 8 {
+		t.Errorf("ShardOf of a struct key = %d, then %d, want one shard below 8", a, b)
+	}
+}
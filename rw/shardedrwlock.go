@@ -2,131 +2,452 @@
 // in concurrent applications by distributing locks across multiple shards based on the hash of a key.
 package cxlockrw
 
-/*
-#cgo LDFLAGS: -lpthread
-#include <pthread.h>
-#include <stdlib.h>
+import (
+	"context"
+	"errors"
+	"log"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// ShardedRWLock provides a set of sharded read-write locks to reduce lock contention.
+//
+// The plain lock methods panic with a *LockError if the underlying lock
+// fails; the Checked variants return the error instead.
+type ShardedRWLock struct {
+	shards    backend
+	numShards int
+	kind      Backend
+	hasher    Hasher
+
+	state     []shardState
+	stateStep int
+	closing   atomic.Bool // set while Close checks the shards, and after it succeeds
+	closed    atomic.Bool
+	closeMu   sync.Mutex
 
-// Initializes a pthread read-write lock.
-void rwlock_init(pthread_rwlock_t *lock) {
-    pthread_rwlock_init(lock, NULL);
+	stats   *lockStats  // nil unless WithStats
+	hot     *hotSpots   // nil unless WithHotSpots
+	profile bool        // WithContentionProfile
+	depID   uint64      // lock-order graph id, with the cxlockdebug tag
+	owners  *ownerTable // nil unless WithOwnerCheck
 }
 
-// Destroys a pthread read-write lock.
-void rwlock_destroy(pthread_rwlock_t *lock) {
-    pthread_rwlock_destroy(lock);
+// shardState is the per-shard bookkeeping kept in Go memory, padded so
+// that each shard's state has its own cache line.
+type shardState struct {
+	shardStateData
+	_ [(minCacheLine - unsafe.Sizeof(shardStateData{})%minCacheLine) % minCacheLine]byte
 }
 
-// Acquires a read lock on a pthread read-write lock.
-void rwlock_rlock(pthread_rwlock_t *lock) {
-    pthread_rwlock_rdlock(lock);
+type shardStateData struct {
+	active atomic.Int32 // goroutines holding or acquiring the shard
 }
 
-// Releases a read lock on a pthread read-write lock.
-void rwlock_runlock(pthread_rwlock_t *lock) {
-    pthread_rwlock_unlock(lock);
+// NewShardedRWLock creates a new ShardedRWLock with a specified number of shards.
+// It panics if the lock cannot be created; use New to handle the error.
+func NewShardedRWLock(numShards int, opts ...Option) *ShardedRWLock {
+	lock, err := New(numShards, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return lock
 }
 
-// Acquires a write lock on a pthread read-write lock.
-void rwlock_lock(pthread_rwlock_t *lock) {
-    pthread_rwlock_wrlock(lock);
+// New creates a new ShardedRWLock with a specified number of shards, failing
+// if any shard's lock cannot be initialized.
+func New(numShards int, opts ...Option) (*ShardedRWLock, error) {
+	if numShards <= 0 {
+		return nil, errors.New("cxlockrw: numShards must be positive")
+	}
+	cfg := newConfig(opts)
+	if !validCacheLine(cfg.cacheLine) {
+		return nil, errors.New("cxlockrw: cache line size must be a power of two from 64 to 512")
+	}
+	kind := cfg.backend.resolve()
+	shards, err := newBackend(kind, numShards, &cfg)
+	if err != nil {
+		return nil, err
+	}
+	return newLock(shards, numShards, kind, &cfg), nil
 }
 
-// Releases a write lock on a pthread read-write lock.
-void rwlock_unlock(pthread_rwlock_t *lock) {
-    pthread_rwlock_unlock(lock);
+// newLock wraps initialized shards in a ShardedRWLock.
+func newLock(shards backend, numShards int, kind Backend, cfg *config) *ShardedRWLock {
+	lock := &ShardedRWLock{
+		shards:    shards,
+		numShards: numShards,
+		kind:      kind,
+		hasher:    cfg.hasher,
+		profile:   cfg.profile,
+	}
+	lock.state, lock.stateStep = alignedSlice[shardState](numShards, cfg.cacheLine)
+	if cfg.ownerCheck {
+		lock.owners = newOwnerTable(numShards, cfg.cacheLine)
+	}
+	depRegister(lock)
+	if cfg.stats {
+		lock.stats = newLockStats(numShards, cfg.cacheLine)
+	}
+	if cfg.hotWindow > 0 {
+		lock.hot = newHotSpots(numShards, cfg.cacheLine, cfg.hotWindow)
+		lock.stats.hot = lock.hot
+	}
+	var created string
+	if cfg.leakCheck {
+		created = callers(3)
+	}
+	runtime.SetFinalizer(lock, func(lock *ShardedRWLock) {
+		if lock.closed.Load() {
+			return
+		}
+		if cfg.leakCheck {
+			log.Printf("cxlockrw: ShardedRWLock with %d shards was garbage collected without Close; created at:\n%s", lock.numShards, created)
+		}
+		depClosed(lock)
+		lock.shards.close()
+	})
+	return lock
 }
-*/
-import "C"
-/*
-import (
-	"hash/fnv"
-)
-*/
 
-// RWLockShard represents a single shard containing a POSIX read-write lock.
-type RWLockShard struct {
-	rwlock C.pthread_rwlock_t
+// callers formats the stack of the caller skip frames up.
+func callers(skip int) string {
+	pcs := make([]uintptr, 32)
+	return formatStack(pcs[:runtime.Callers(skip, pcs)])
 }
 
-// init initializes the shard's read-write lock.
-func (shard *RWLockShard) init() {
-	C.rwlock_init(&shard.rwlock)
+// formatStack formats the stack of program counters pcs.
+func formatStack(pcs []uintptr) string {
+	frames := runtime.CallersFrames(pcs)
+	var b strings.Builder
+	for {
+		frame, more := frames.Next()
+		b.WriteString("\t" + frame.Function + "\n\t\t" + frame.File + ":" + strconv.Itoa(frame.Line) + "\n")
+		if !more {
+			return b.String()
+		}
+	}
 }
 
-// destroy destroys the shard's read-write lock.
-func (shard *RWLockShard) destroy() {
-	C.rwlock_destroy(&shard.rwlock)
+// Backend returns the implementation backing the lock.
+func (lock *ShardedRWLock) Backend() Backend {
+	return lock.kind
 }
 
-// rlock acquires a read lock for the shard.
-func (shard *RWLockShard) rlock() {
-	C.rwlock_rlock(&shard.rwlock)
+// Close cleans up resources used by the ShardedRWLock. It refuses with
+// ErrHeld while any shard is held or being acquired, leaving the lock usable.
+// Once Close succeeds every acquisition and release returns or panics with
+// ErrClosed, and further calls to Close do nothing.
+func (lock *ShardedRWLock) Close() error {
+	return lock.closeIf(nil)
 }
 
-// runlock releases a read lock for the shard.
-func (shard *RWLockShard) runlock() {
-	C.rwlock_runlock(&shard.rwlock)
+// closeIf is Close with an extra check of state guarded by the shards, such
+// as the key tables of a KeyedLock. check runs once no shard is held or being
+// acquired, while new acquisitions wait; if it fails, Close is refused with
+// its error.
+func (lock *ShardedRWLock) closeIf(check func() error) error {
+	lock.closeMu.Lock()
+	defer lock.closeMu.Unlock()
+	if lock.closed.Load() {
+		return nil
+	}
+	lock.closing.Store(true)
+	for i := 0; i < lock.numShards; i++ {
+		if lock.shardState(uint32(i)).active.Load() != 0 {
+			lock.closing.Store(false)
+			return &LockError{Op: "close", Shard: uint32(i), Err: ErrHeld}
+		}
+	}
+	if check != nil {
+		if err := check(); err != nil {
+			lock.closing.Store(false)
+			return err
+		}
+	}
+	lock.closed.Store(true)
+	depClosed(lock)
+	return lock.shards.close()
 }
 
-// lock acquires a write lock for the shard.
-func (shard *RWLockShard) lock() {
-	C.rwlock_lock(&shard.rwlock)
+// enter registers an acquisition of shard, failing if the lock is closed.
+// A successful enter must be paired with leave once the shard is released
+// or the acquisition fails. A holder keeps the shard active, so Close cannot
+// succeed under it; a release that finds the lock closed is therefore one
+// without a matching acquisition, and fails with ErrClosed before touching
+// the released shards.
+func (lock *ShardedRWLock) enter(shard uint32) error {
+	st := lock.shardState(shard)
+	for {
+		st.active.Add(1)
+		if !lock.closing.Load() {
+			return nil
+		}
+		st.active.Add(-1)
+		if lock.closed.Load() {
+			return ErrClosed
+		}
+		// Wait for the Close that is checking the shards to decide.
+		lock.closeMu.Lock()
+		lock.closeMu.Unlock()
+	}
 }
 
-// unlock releases a write lock for the shard.
-func (shard *RWLockShard) unlock() {
-	C.rwlock_unlock(&shard.rwlock)
+// leave ends the acquisition registered by enter.
+func (lock *ShardedRWLock) leave(shard uint32) {
+	lock.shardState(shard).active.Add(-1)
 }
 
-// ShardedRWLock provides a set of sharded read-write locks to reduce lock contention.
-type ShardedRWLock struct {
-	shards []RWLockShard
+// shardState returns the bookkeeping for shard.
+func (lock *ShardedRWLock) shardState(shard uint32) *shardState {
+	return &lock.state[int(shard)*lock.stateStep]
 }
 
-// NewShardedRWLock creates a new ShardedRWLock with a specified number of shards.
-func NewShardedRWLock(numShards int) *ShardedRWLock {
-	lock := &ShardedRWLock{
-		shards: make([]RWLockShard, numShards),
+// RLock acquires a read lock for the given shard.
+func (lock *ShardedRWLock) RLock(shardnum uint32) {
+	must(lock.RLockChecked(shardnum))
+}
+
+// RUnlock releases a read lock for the given shard.
+func (lock *ShardedRWLock) RUnlock(shardnum uint32) {
+	must(lock.RUnlockChecked(shardnum))
+}
+
+// Lock acquires a write lock for the given shard.
+func (lock *ShardedRWLock) Lock(shardnum uint32) {
+	must(lock.LockChecked(shardnum))
+}
+
+// Unlock releases a write lock for the given shard.
+func (lock *ShardedRWLock) Unlock(shardnum uint32) {
+	must(lock.UnlockChecked(shardnum))
+}
+
+// RLockChecked acquires a read lock for the given shard, reporting failures
+// such as ErrDeadlock or ErrTooManyReaders.
+func (lock *ShardedRWLock) RLockChecked(shardnum uint32) error {
+	if err := lock.enter(shardnum); err != nil {
+		return wrapErr("rlock", shardnum, err)
 	}
-	for i := range lock.shards {
-		lock.shards[i].init()
+	if err := lock.ownerAcquire(shardnum, holdRead); err != nil {
+		lock.leave(shardnum)
+		return wrapErr("rlock", shardnum, err)
 	}
-	return lock
+	depAcquire(lock, shardnum, holdRead)
+	var err error
+	if lock.stats != nil {
+		err = lock.acquireCounted(context.Background(), shardnum, false)
+	} else {
+		err = lock.shards.rlock(shardnum)
+	}
+	if err != nil {
+		lock.leave(shardnum)
+		return wrapErr("rlock", shardnum, err)
+	}
+	lock.ownerAcquired(shardnum, holdRead)
+	depAcquired(lock, shardnum, holdRead)
+	return nil
 }
 
-// Close cleans up resources used by the ShardedRWLock.
-func (lock *ShardedRWLock) Close() {
-	for i := range lock.shards {
-		lock.shards[i].destroy()
+// RUnlockChecked releases a read lock for the given shard, reporting failures
+// such as ErrNotHeld.
+func (lock *ShardedRWLock) RUnlockChecked(shardnum uint32) error {
+	if lock.closed.Load() {
+		return wrapErr("runlock", shardnum, ErrClosed)
+	}
+	g, err := lock.ownerRelease(shardnum, holdRead)
+	if err != nil {
+		return wrapErr("runlock", shardnum, err)
 	}
+	if err := lock.shards.runlock(shardnum); err != nil {
+		lock.ownerRecord(shardnum, g, holdRead)
+		return wrapErr("runlock", shardnum, err)
+	}
+	if lock.stats != nil {
+		lock.stats.released(shardnum, false, 0)
+	}
+	depReleased(lock, shardnum, holdRead)
+	lock.leave(shardnum)
+	return nil
 }
-/*
-// getShard selects the appropriate shard based on the hash of a key.
-func (lock *ShardedRWLock) getShard(key string) *RWLockShard {
-	hasher := fnv.New32a()
-	_, _ = hasher.Write([]byte(key))
-	hash := hasher.Sum32()
-	return &lock.shards[hash%uint32(len(lock.shards))]
+
+// LockChecked acquires a write lock for the given shard, reporting failures
+// such as ErrDeadlock.
+func (lock *ShardedRWLock) LockChecked(shardnum uint32) error {
+	if err := lock.enter(shardnum); err != nil {
+		return wrapErr("lock", shardnum, err)
+	}
+	if err := lock.ownerAcquire(shardnum, holdWrite); err != nil {
+		lock.leave(shardnum)
+		return wrapErr("lock", shardnum, err)
+	}
+	depAcquire(lock, shardnum, holdWrite)
+	var err error
+	if lock.stats != nil {
+		err = lock.acquireCounted(context.Background(), shardnum, true)
+	} else {
+		err = lock.shards.lock(shardnum)
+	}
+	if err != nil {
+		lock.leave(shardnum)
+		return wrapErr("lock", shardnum, err)
+	}
+	lock.ownerAcquired(shardnum, holdWrite)
+	depAcquired(lock, shardnum, holdWrite)
+	lock.shards.seq(shardnum).Add(1)
+	return nil
 }
-*/
 
-// RLock acquires a read lock for the shard corresponding to the provided key.
-func (lock *ShardedRWLock) RLock(shardnum uint32) {
-	lock.shards[shardnum].rlock()
+// UnlockChecked releases a write lock for the given shard, reporting failures
+// such as ErrNotHeld.
+func (lock *ShardedRWLock) UnlockChecked(shardnum uint32) error {
+	if lock.closed.Load() {
+		return wrapErr("unlock", shardnum, ErrClosed)
+	}
+	g, err := lock.ownerRelease(shardnum, holdWrite)
+	if err != nil {
+		return wrapErr("unlock", shardnum, err)
+	}
+	var since int64
+	if lock.stats != nil {
+		since = lock.stats.shard(shardnum).writeSince.Load()
+	}
+	seq := lock.shards.seq(shardnum)
+	seq.Add(1)
+	if err := lock.shards.unlock(shardnum); err != nil {
+		seq.Add(^uint64(0))
+		lock.ownerRecord(shardnum, g, holdWrite)
+		return wrapErr("unlock", shardnum, err)
+	}
+	if lock.stats != nil {
+		lock.stats.released(shardnum, true, since)
+	}
+	depReleased(lock, shardnum, holdWrite)
+	lock.leave(shardnum)
+	return nil
 }
 
-// RUnlock releases a read lock for the shard corresponding to the provided key.
-func (lock *ShardedRWLock) RUnlock(shardnum uint32) {
-	lock.shards[shardnum].runlock()
+// must panics if err is not nil.
+func must(err error) {
+	if err != nil {
+		panic(err)
+	}
 }
 
-// Lock acquires a write lock for the shard corresponding to the provided key.
-func (lock *ShardedRWLock) Lock(shardnum uint32) {
-	lock.shards[shardnum].lock()
+// TryRLock attempts to acquire a read lock for the given shard without blocking.
+// It returns false with a nil error when a writer holds the shard, and a
+// non-nil error only when the underlying lock reports a failure such as
+// EAGAIN (too many readers) or EDEADLK.
+func (lock *ShardedRWLock) TryRLock(shardnum uint32) (bool, error) {
+	if err := lock.enter(shardnum); err != nil {
+		return false, wrapErr("tryrlock", shardnum, err)
+	}
+	ok, err := lock.shards.tryRLock(shardnum)
+	if !ok {
+		lock.leave(shardnum)
+	} else {
+		lock.ownerAcquired(shardnum, holdRead)
+		depAcquired(lock, shardnum, holdRead)
+	}
+	if lock.stats != nil && err == nil {
+		lock.tryCounted(shardnum, false, ok)
+	}
+	return ok, wrapErr("tryrlock", shardnum, err)
 }
 
-// Unlock releases a write lock for the shard corresponding to the provided key.
-func (lock *ShardedRWLock) Unlock(shardnum uint32) {
-	lock.shards[shardnum].unlock()
+// TryLock attempts to acquire a write lock for the given shard without blocking.
+// It returns false with a nil error when the shard is held by anyone else, and
+// a non-nil error only when the underlying lock reports a failure such as EDEADLK.
+func (lock *ShardedRWLock) TryLock(shardnum uint32) (bool, error) {
+	if err := lock.enter(shardnum); err != nil {
+		return false, wrapErr("trylock", shardnum, err)
+	}
+	ok, err := lock.shards.tryLock(shardnum)
+	if !ok {
+		lock.leave(shardnum)
+	} else {
+		lock.shards.seq(shardnum).Add(1)
+		lock.ownerAcquired(shardnum, holdWrite)
+		depAcquired(lock, shardnum, holdWrite)
+	}
+	if lock.stats != nil && err == nil {
+		lock.tryCounted(shardnum, true, ok)
+	}
+	return ok, wrapErr("trylock", shardnum, err)
+}
+
+// RLockContext acquires a read lock for the given shard, waiting until ctx is done.
+// It returns ctx.Err() if the lock could not be acquired in time, in which case
+// the shard is not held. A context that is already done never acquires the lock.
+func (lock *ShardedRWLock) RLockContext(ctx context.Context, shardnum uint32) error {
+	if err := lock.enter(shardnum); err != nil {
+		return wrapErr("rlock", shardnum, err)
+	}
+	if err := lock.ownerAcquire(shardnum, holdRead); err != nil {
+		lock.leave(shardnum)
+		return wrapErr("rlock", shardnum, err)
+	}
+	depAcquire(lock, shardnum, holdRead)
+	var err error
+	if lock.stats != nil {
+		err = lock.acquireCounted(ctx, shardnum, false)
+	} else {
+		err = lock.shards.rlockContext(ctx, shardnum)
+	}
+	if err != nil {
+		lock.leave(shardnum)
+		return wrapErr("rlock", shardnum, err)
+	}
+	lock.ownerAcquired(shardnum, holdRead)
+	depAcquired(lock, shardnum, holdRead)
+	return nil
+}
+
+// LockContext acquires a write lock for the given shard, waiting until ctx is done.
+// It returns ctx.Err() if the lock could not be acquired in time, in which case
+// the shard is not held. A context that is already done never acquires the lock.
+func (lock *ShardedRWLock) LockContext(ctx context.Context, shardnum uint32) error {
+	if err := lock.enter(shardnum); err != nil {
+		return wrapErr("lock", shardnum, err)
+	}
+	if err := lock.ownerAcquire(shardnum, holdWrite); err != nil {
+		lock.leave(shardnum)
+		return wrapErr("lock", shardnum, err)
+	}
+	depAcquire(lock, shardnum, holdWrite)
+	var err error
+	if lock.stats != nil {
+		err = lock.acquireCounted(ctx, shardnum, true)
+	} else {
+		err = lock.shards.lockContext(ctx, shardnum)
+	}
+	if err != nil {
+		lock.leave(shardnum)
+		return wrapErr("lock", shardnum, err)
+	}
+	lock.ownerAcquired(shardnum, holdWrite)
+	depAcquired(lock, shardnum, holdWrite)
+	lock.shards.seq(shardnum).Add(1)
+	return nil
+}
+
+// RLockTimeout acquires a read lock for the given shard, waiting at most timeout.
+// It returns context.DeadlineExceeded if the lock could not be acquired in time.
+func (lock *ShardedRWLock) RLockTimeout(shardnum uint32, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return lock.RLockContext(ctx, shardnum)
+}
+
+// LockTimeout acquires a write lock for the given shard, waiting at most timeout.
+// It returns context.DeadlineExceeded if the lock could not be acquired in time.
+func (lock *ShardedRWLock) LockTimeout(shardnum uint32, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return lock.LockContext(ctx, shardnum)
 }
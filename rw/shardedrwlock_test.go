@@ -0,0 +1,89 @@
+package cxlockrw
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCloseHeld(t *testing.T) {
+	eachBackend(t, func(t *testing.T, b Backend) {
+		lock := newTestLock(t, b, 4)
+		lock.RLock(2)
+		if err := lock.Close(); !errors.Is(err, ErrHeld) {
+			t.Fatalf("Close with a read-locked shard = %v, want ErrHeld", err)
+		}
+		lock.RUnlock(2)
+		lock.Lock(3)
+		if err := lock.Close(); !errors.Is(err, ErrHeld) {
+			t.Fatalf("Close with a write-locked shard = %v, want ErrHeld", err)
+		}
+		lock.Unlock(3)
+		if err := lock.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		if err := lock.Close(); err != nil {
+			t.Fatalf("second Close: %v", err)
+		}
+		if err := lock.LockChecked(0); !errors.Is(err, ErrClosed) {
+			t.Fatalf("LockChecked after Close = %v, want ErrClosed", err)
+		}
+		if ok, err := lock.TryRLock(0); ok || !errors.Is(err, ErrClosed) {
+			t.Fatalf("TryRLock after Close = %v, %v, want false, ErrClosed", ok, err)
+		}
+	})
+}
+
+// A refused Close must not disturb goroutines using other shards.
+func TestCloseRefusedKeepsLockUsable(t *testing.T) {
+	eachBackend(t, func(t *testing.T, b Backend) {
+		// Close scans the shards in order, so hold the last of many to keep it
+		// deciding for a while.
+		lock := newTestLock(t, b, 1<<14)
+		lock.Lock(1<<14 - 1)
+		stop := make(chan struct{})
+		var refused atomic.Int32
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if err := lock.Close(); !errors.Is(err, ErrHeld) {
+					t.Errorf("Close with a held shard = %v, want ErrHeld", err)
+					return
+				}
+				refused.Add(1)
+			}
+		}()
+		for i := 0; i < 1000 || refused.Load() < 1000; i++ {
+			if err := lock.LockChecked(0); err != nil {
+				t.Fatalf("LockChecked: %v", err)
+			}
+			if err := lock.UnlockChecked(0); err != nil {
+				t.Fatalf("UnlockChecked: %v", err)
+			}
+			if err := lock.RLockChecked(0); err != nil {
+				t.Fatalf("RLockChecked: %v", err)
+			}
+			if err := lock.RUnlockChecked(0); err != nil {
+				t.Fatalf("RUnlockChecked: %v", err)
+			}
+		}
+		close(stop)
+		wg.Wait()
+		lock.Unlock(1<<14 - 1)
+		if ok, err := lock.TryLock(0); !ok || err != nil {
+			t.Fatalf("TryLock after the refused Closes = %v, %v, want true, nil", ok, err)
+		}
+		lock.Unlock(0)
+		if err := lock.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+	})
+}
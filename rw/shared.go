@@ -0,0 +1,40 @@
+package cxlockrw
+
+import "errors"
+
+// NewSharedShardedRWLock opens a ShardedRWLock whose shards live in the
+// memory-mapped file at path, so that every process mapping the same file
+// shares the same locks.
+//
+// The first caller creates the file and initializes the shards as
+// process-shared pthread locks; later callers attach to it after checking that
+// the file's layout version, shard count and lock size match. Creation and
+// attachment are serialized with flock, so processes may race to open the
+// file. All processes must use the same Hasher for the key-based methods.
+//
+// Close unmaps the file but leaves the locks in place for other processes;
+// remove the file once no process uses it. A process that exits while holding
+// a shard leaves it locked. Shared locks require the pthread backend.
+func NewSharedShardedRWLock(path string, numShards int, opts ...Option) (*ShardedRWLock, error) {
+	if numShards <= 0 {
+		return nil, errors.New("cxlockrw: numShards must be positive")
+	}
+	cfg := newConfig(opts)
+	if !validCacheLine(cfg.cacheLine) {
+		return nil, errors.New("cxlockrw: cache line size must be a power of two from 64 to 512")
+	}
+	if kind := cfg.backend.resolve(); kind != BackendPthread {
+		return nil, errors.New("cxlockrw: shared locks require the pthread backend")
+	}
+	if cfg.policy == PolicyFIFO {
+		return nil, errors.New("cxlockrw: PolicyFIFO requires BackendGo")
+	}
+	if cfg.bigReader {
+		return nil, errors.New("cxlockrw: shared locks cannot use per-CPU readers")
+	}
+	shards, err := openSharedBackend(path, numShards, &cfg)
+	if err != nil {
+		return nil, err
+	}
+	return newLock(shards, numShards, BackendPthread, &cfg), nil
+}
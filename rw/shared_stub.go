@@ -0,0 +1,11 @@
+//go:build !cgo || purego || !(linux || darwin)
+// +build !cgo purego !linux,!darwin
+
+package cxlockrw
+
+import "errors"
+
+// openSharedBackend is unavailable without cgo on Linux or macOS.
+func openSharedBackend(path string, numShards int, cfg *config) (backend, error) {
+	return nil, errors.New("cxlockrw: shared locks are not supported in this build")
+}
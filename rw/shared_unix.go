@@ -0,0 +1,153 @@
+//go:build cgo && !purego && (linux || darwin)
+// +build cgo
+// +build !purego
+// +build linux darwin
+
+package cxlockrw
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// sharedMagic identifies a shared lock file.
+	sharedMagic = "CXLOCKRW"
+	// sharedLayoutVersion changes whenever the file layout changes.
+	// Version 2 pads shards to the cache line, version 3 adds the upgrade
+	// mutex and version 4 the write sequence.
+	sharedLayoutVersion = 4
+	// sharedHeaderSize is the minimum space reserved for the header; shards
+	// start at the next cache line boundary.
+	sharedHeaderSize = 64
+)
+
+// sharedHeader is stored at the start of a shared lock file.
+type sharedHeader struct {
+	magic     [8]byte
+	version   uint32
+	ready     uint32 // set once every shard is initialized
+	numShards uint32
+	shardSize uint32 // distance between shards in bytes
+	lockSize  uint32 // sizeof(pthread_rwlock_t) of the creating process
+	policy    uint32 // Policy the shards were initialized with
+}
+
+// sharedBackend is a pthreadBackend whose shards live in a mapped file.
+type sharedBackend struct {
+	pthreadBackend
+}
+
+// openSharedBackend creates or attaches to the shared lock file at path.
+func openSharedBackend(path string, numShards int, cfg *config) (backend, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fd := int(f.Fd())
+	if err := syscall.Flock(fd, syscall.LOCK_EX); err != nil {
+		return nil, &os.PathError{Op: "flock", Path: path, Err: err}
+	}
+	defer syscall.Flock(fd, syscall.LOCK_UN)
+
+	policy := cfg.policy
+	shardSize := pthreadStride(cfg.cacheLine)
+	offset := roundUp(sharedHeaderSize, uintptr(cfg.cacheLine))
+	size := int64(offset) + int64(numShards)*int64(shardSize)
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	create := true
+	if info.Size() > 0 {
+		var hdr sharedHeader
+		buf := unsafe.Slice((*byte)(unsafe.Pointer(&hdr)), unsafe.Sizeof(hdr))
+		if _, err := f.ReadAt(buf, 0); err != nil {
+			return nil, fmt.Errorf("cxlockrw: shared lock file %s: reading header: %w", path, err)
+		}
+		switch {
+		case hdr.ready != 0:
+			if err := hdr.validate(numShards, shardSize, policy); err != nil {
+				return nil, fmt.Errorf("cxlockrw: shared lock file %s: %w", path, err)
+			}
+			if info.Size() != size {
+				return nil, fmt.Errorf("cxlockrw: shared lock file %s has size %d, want %d", path, info.Size(), size)
+			}
+			create = false
+		case hdr != sharedHeader{} && string(hdr.magic[:]) != sharedMagic:
+			return nil, fmt.Errorf("cxlockrw: %s is not a shared lock file", path)
+		}
+		// Otherwise a creator died before setting ready and left the file
+		// zero-filled or half initialized; redo the whole setup.
+	}
+	if create {
+		if err := f.Truncate(0); err != nil {
+			return nil, err
+		}
+		if err := f.Truncate(size); err != nil {
+			return nil, err
+		}
+	}
+	mem, err := syscall.Mmap(fd, 0, int(size), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, &os.PathError{Op: "mmap", Path: path, Err: err}
+	}
+	b := &sharedBackend{}
+	b.mem, b.base, b.stride, b.n = mem, unsafe.Pointer(&mem[offset]), shardSize, numShards
+
+	if create {
+		hdr := (*sharedHeader)(unsafe.Pointer(&mem[0]))
+		if err := b.initShared(hdr, numShards, shardSize, policy); err != nil {
+			syscall.Munmap(mem)
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// initShared initializes every shard as a process-shared lock and then
+// publishes the header.
+func (b *sharedBackend) initShared(hdr *sharedHeader, numShards int, shardSize uintptr, policy Policy) error {
+	if err := b.init(policy, true); err != nil {
+		return err
+	}
+	copy(hdr.magic[:], sharedMagic)
+	hdr.version = sharedLayoutVersion
+	hdr.numShards = uint32(numShards)
+	hdr.shardSize = uint32(shardSize)
+	hdr.lockSize = uint32(unsafe.Sizeof(RWLockShard{}))
+	hdr.policy = uint32(policy)
+	atomic.StoreUint32(&hdr.ready, 1)
+	return nil
+}
+
+// validate checks that an existing file matches the requested layout.
+func (hdr *sharedHeader) validate(numShards int, shardSize uintptr, policy Policy) error {
+	switch {
+	case string(hdr.magic[:]) != sharedMagic:
+		return errors.New("not a shared lock file")
+	case hdr.version != sharedLayoutVersion:
+		return fmt.Errorf("layout version %d, want %d", hdr.version, sharedLayoutVersion)
+	case hdr.numShards != uint32(numShards):
+		return fmt.Errorf("%d shards, want %d", hdr.numShards, numShards)
+	case hdr.shardSize != uint32(shardSize):
+		return fmt.Errorf("shard size %d, want %d", hdr.shardSize, shardSize)
+	case hdr.lockSize != uint32(unsafe.Sizeof(RWLockShard{})):
+		return fmt.Errorf("lock size %d, want %d", hdr.lockSize, unsafe.Sizeof(RWLockShard{}))
+	case hdr.policy != uint32(policy):
+		return fmt.Errorf("policy %v, want %v", Policy(hdr.policy), policy)
+	}
+	return nil
+}
+
+// close unmaps the file. The locks are not destroyed because other
+// processes may still be using them.
+func (b *sharedBackend) close() error {
+	b.n = 0
+	return syscall.Munmap(b.mem)
+}
@@ -0,0 +1,126 @@
+//go:build cgo && !purego && (linux || darwin)
+// +build cgo
+// +build !purego
+// +build linux darwin
+
+package cxlockrw
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+// openShared opens the shared lock at path and closes it when the test ends.
+func openShared(t *testing.T, path string, numShards int) *ShardedRWLock {
+	t.Helper()
+	lock, err := NewSharedShardedRWLock(path, numShards)
+	if err != nil {
+		t.Fatalf("NewSharedShardedRWLock: %v", err)
+	}
+	t.Cleanup(func() { lock.Close() })
+	return lock
+}
+
+// tryLockOther reports whether shard 0 of lock can be write-locked from
+// another thread, releasing it again if so.
+func tryLockOther(lock *ShardedRWLock) bool {
+	return onOtherThread(func() bool {
+		ok, _ := lock.TryLock(0)
+		if ok {
+			lock.Unlock(0)
+		}
+		return ok
+	})
+}
+
+// Two handles on the same file share the locks.
+func TestSharedCreateAttach(t *testing.T) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	path := filepath.Join(t.TempDir(), "lock")
+	created := openShared(t, path, 4)
+	attached := openShared(t, path, 4)
+	created.Lock(0)
+	if tryLockOther(attached) {
+		t.Error("TryLock through the second handle succeeded while the first held the shard")
+	}
+	created.Unlock(0)
+	if !tryLockOther(attached) {
+		t.Error("TryLock through the second handle failed after the first released the shard")
+	}
+	attached.RLock(1)
+	if ok, err := created.TryRLock(1); !ok || err != nil {
+		t.Errorf("TryRLock beside a reader of the other handle = %v, %v, want true, nil", ok, err)
+	} else {
+		created.RUnlock(1)
+	}
+	attached.RUnlock(1)
+}
+
+func TestSharedRejectsMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lock")
+	lock, err := NewSharedShardedRWLock(path, 4)
+	if err != nil {
+		t.Fatalf("NewSharedShardedRWLock: %v", err)
+	}
+	if err := lock.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := NewSharedShardedRWLock(path, 8); err == nil || !strings.Contains(err.Error(), "4 shards, want 8") {
+		t.Errorf("attaching with another shard count = %v, want a shard count error", err)
+	}
+
+	// Pretend the file was created by a process with another pthread_rwlock_t.
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var size [4]byte
+	*(*uint32)(unsafe.Pointer(&size[0])) = uint32(unsafe.Sizeof(RWLockShard{})) + 8
+	_, err = f.WriteAt(size[:], int64(unsafe.Offsetof(sharedHeader{}.lockSize)))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewSharedShardedRWLock(path, 4); err == nil || !strings.Contains(err.Error(), "lock size") {
+		t.Errorf("attaching with another lock size = %v, want a lock size error", err)
+	}
+
+	if err := os.WriteFile(path, []byte("not a lock file"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewSharedShardedRWLock(path, 4); err == nil {
+		t.Error("attaching to a foreign file succeeded")
+	}
+}
+
+// Closing a handle unmaps the file but leaves the locks to the other handles.
+func TestSharedCloseUnmapsOnly(t *testing.T) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	path := filepath.Join(t.TempDir(), "lock")
+	first, err := NewSharedShardedRWLock(path, 2)
+	if err != nil {
+		t.Fatalf("NewSharedShardedRWLock: %v", err)
+	}
+	holder := openShared(t, path, 2)
+	holder.Lock(0)
+	if err := first.Close(); err != nil {
+		t.Fatalf("Close of an idle handle: %v", err)
+	}
+	observer := openShared(t, path, 2)
+	if tryLockOther(observer) {
+		t.Error("TryLock succeeded on a shard held through another handle after Close")
+	}
+	holder.Unlock(0)
+	if !tryLockOther(observer) {
+		t.Error("TryLock failed after the holder released the shard")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("lock file after Close: %v", err)
+	}
+}
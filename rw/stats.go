@@ -0,0 +1,341 @@
+package cxlockrw
+
+import (
+	"context"
+	"math/bits"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// HistogramBuckets is the number of buckets in a Histogram.
+const HistogramBuckets = 40
+
+// Histogram counts durations in power-of-two buckets: bucket 0 holds zero
+// durations and bucket i holds durations from 2^(i-1) up to 2^i nanoseconds.
+// The last bucket also holds everything longer.
+type Histogram struct {
+	Counts [HistogramBuckets]uint64
+	Sum    time.Duration
+}
+
+// BucketBound returns the upper bound of bucket i of a Histogram.
+func BucketBound(i int) time.Duration {
+	if i >= HistogramBuckets-1 {
+		return time.Duration(1<<63 - 1)
+	}
+	return time.Duration(1) << i
+}
+
+// Count returns the number of recorded durations.
+func (h *Histogram) Count() uint64 {
+	var n uint64
+	for _, c := range h.Counts {
+		n += c
+	}
+	return n
+}
+
+// Mean returns the average recorded duration.
+func (h *Histogram) Mean() time.Duration {
+	n := h.Count()
+	if n == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(n)
+}
+
+// Quantile returns the upper bound of the bucket containing the q-quantile,
+// for q from 0 to 1.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	n := h.Count()
+	if n == 0 {
+		return 0
+	}
+	rank := uint64(q * float64(n))
+	if rank >= n {
+		rank = n - 1
+	}
+	var seen uint64
+	for i, c := range h.Counts {
+		seen += c
+		if seen > rank {
+			return BucketBound(i)
+		}
+	}
+	return BucketBound(HistogramBuckets - 1)
+}
+
+// Sub returns the durations recorded in h but not in old, an earlier
+// snapshot of the same histogram.
+func (h Histogram) Sub(old Histogram) Histogram {
+	for i := range h.Counts {
+		h.Counts[i] -= old.Counts[i]
+	}
+	h.Sum -= old.Sum
+	return h
+}
+
+// ModeStats describes the acquisitions of a shard in one mode.
+type ModeStats struct {
+	Acquires  uint64    // successful acquisitions
+	Contended uint64    // acquisitions that had to wait, and failed TryLocks
+	Wait      Histogram // time spent waiting by contended acquisitions
+	Hold      Histogram // time held
+}
+
+// Sub returns the activity recorded in s but not in old.
+func (s ModeStats) Sub(old ModeStats) ModeStats {
+	return ModeStats{
+		Acquires:  s.Acquires - old.Acquires,
+		Contended: s.Contended - old.Contended,
+		Wait:      s.Wait.Sub(old.Wait),
+		Hold:      s.Hold.Sub(old.Hold),
+	}
+}
+
+// ShardStats describes the activity of one shard since the lock was created.
+//
+// Upgradable read locks count as reads until they are upgraded. Read hold
+// times measure how long the shard was continuously read-locked by one or
+// more readers, since readers are not told apart.
+type ShardStats struct {
+	Shard uint32
+	Read  ModeStats
+	Write ModeStats
+}
+
+// Sub returns the activity recorded in s but not in old.
+func (s ShardStats) Sub(old ShardStats) ShardStats {
+	return ShardStats{Shard: s.Shard, Read: s.Read.Sub(old.Read), Write: s.Write.Sub(old.Write)}
+}
+
+// Stats returns a snapshot of every shard's statistics, or nil if the lock
+// was created without WithStats. Each counter is read atomically, but the
+// snapshot as a whole is not.
+func (lock *ShardedRWLock) Stats() []ShardStats {
+	if lock.stats == nil {
+		return nil
+	}
+	out := make([]ShardStats, lock.numShards)
+	for i := range out {
+		st := lock.stats.shard(uint32(i))
+		out[i] = ShardStats{Shard: uint32(i), Read: st.read.snapshot(), Write: st.write.snapshot()}
+	}
+	return out
+}
+
+// readHoldBits is the width of the read-held period start in shardStats.readers.
+const readHoldBits = 44
+
+// lockStats holds the statistics of every shard of a lock.
+type lockStats struct {
+	base   time.Time
+	shards []statsShard
+	step   int
+	hot    *hotSpots // nil unless WithHotSpots
+}
+
+// statsShard is a shard's statistics, padded to the cache line.
+type statsShard struct {
+	statsShardData
+	_ [(minCacheLine - unsafe.Sizeof(statsShardData{})%minCacheLine) % minCacheLine]byte
+}
+
+type statsShardData struct {
+	read, write modeCounters
+	// readers packs the start of the current read-held period, in
+	// nanoseconds modulo 2^44, above a 20-bit count of readers.
+	readers    atomic.Uint64
+	writeSince atomic.Int64
+}
+
+// modeCounters is the live form of ModeStats.
+type modeCounters struct {
+	acquires, contended atomic.Uint64
+	wait, hold          histogramCounters
+}
+
+// histogramCounters is the live form of Histogram.
+type histogramCounters struct {
+	counts [HistogramBuckets]atomic.Uint64
+	sum    atomic.Int64
+}
+
+func newLockStats(numShards, line int) *lockStats {
+	st := &lockStats{base: time.Now()}
+	st.shards, st.step = alignedSlice[statsShard](numShards, line)
+	return st
+}
+
+// now returns the time since the stats were created.
+func (st *lockStats) now() int64 {
+	return int64(time.Since(st.base))
+}
+
+func (st *lockStats) shard(shard uint32) *statsShard {
+	return &st.shards[int(shard)*st.step]
+}
+
+func (h *histogramCounters) record(d int64) {
+	if d < 0 {
+		d = 0
+	}
+	i := bits.Len64(uint64(d))
+	if i >= HistogramBuckets {
+		i = HistogramBuckets - 1
+	}
+	h.counts[i].Add(1)
+	h.sum.Add(d)
+}
+
+func (h *histogramCounters) snapshot() Histogram {
+	var s Histogram
+	for i := range h.counts {
+		s.Counts[i] = h.counts[i].Load()
+	}
+	s.Sum = time.Duration(h.sum.Load())
+	return s
+}
+
+func (m *modeCounters) snapshot() ModeStats {
+	return ModeStats{
+		Acquires:  m.acquires.Load(),
+		Contended: m.contended.Load(),
+		Wait:      m.wait.snapshot(),
+		Hold:      m.hold.snapshot(),
+	}
+}
+
+// mode returns the counters for read or write mode.
+func (s *statsShard) mode(write bool) *modeCounters {
+	if write {
+		return &s.write
+	}
+	return &s.read
+}
+
+// readAcquired starts a read-held period if the shard had no readers.
+func (s *statsShard) readAcquired(now int64) {
+	const countMask = 1<<(64-readHoldBits) - 1
+	for {
+		old := s.readers.Load()
+		next := old + 1
+		if old&countMask == 0 {
+			next = uint64(now)<<(64-readHoldBits) | 1
+		}
+		if s.readers.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+// readReleased ends the read-held period if the last reader left.
+func (s *statsShard) readReleased(now int64) {
+	const countMask = 1<<(64-readHoldBits) - 1
+	for {
+		old := s.readers.Load()
+		if old&countMask == 0 {
+			return
+		}
+		next := old - 1
+		if old&countMask == 1 {
+			next = 0
+		}
+		if s.readers.CompareAndSwap(old, next) {
+			if next == 0 {
+				start := old >> (64 - readHoldBits)
+				s.read.hold.record(int64((uint64(now) - start) & (1<<readHoldBits - 1)))
+			}
+			return
+		}
+	}
+}
+
+// acquired records a successful acquisition after waiting since start, or
+// without waiting if start is negative.
+func (st *lockStats) acquired(shard uint32, write bool, start int64) {
+	s := st.shard(shard)
+	m := s.mode(write)
+	now := st.now()
+	m.acquires.Add(1)
+	wait := int64(-1)
+	if start >= 0 {
+		wait = now - start
+		m.contended.Add(1)
+		m.wait.record(wait)
+	}
+	if st.hot != nil {
+		st.hot.record(shard, wait)
+	}
+	if write {
+		s.writeSince.Store(now)
+	} else {
+		s.readAcquired(now)
+	}
+}
+
+// released records the end of a hold. For a write, since is the
+// writeSince value read before the shard was unlocked.
+func (st *lockStats) released(shard uint32, write bool, since int64) {
+	s := st.shard(shard)
+	now := st.now()
+	if write {
+		s.write.hold.record(now - since)
+	} else {
+		s.readReleased(now)
+	}
+}
+
+// acquireCounted acquires shard in the given mode for a lock with stats. It
+// tries first so that contended acquisitions can be told apart and timed.
+func (lock *ShardedRWLock) acquireCounted(ctx context.Context, shard uint32, write bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var ok bool
+	var err error
+	if write {
+		ok, err = lock.shards.tryLock(shard)
+	} else {
+		ok, err = lock.shards.tryRLock(shard)
+	}
+	if err != nil {
+		return err
+	}
+	start := int64(-1)
+	if !ok {
+		start = lock.stats.now()
+		wait := func() error {
+			if write {
+				return lock.shards.lockContext(ctx, shard)
+			}
+			return lock.shards.rlockContext(ctx, shard)
+		}
+		if lock.profile {
+			err = waitTraced(ctx, shard, write, wait)
+		} else {
+			err = wait()
+		}
+		if err != nil {
+			return err
+		}
+		if lock.profile {
+			recordContention(time.Duration(lock.stats.now()-start), 1)
+		}
+	}
+	lock.stats.acquired(shard, write, start)
+	return nil
+}
+
+// tryCounted records the outcome of a TryLock or TryRLock.
+func (lock *ShardedRWLock) tryCounted(shard uint32, write, ok bool) {
+	if ok {
+		lock.stats.acquired(shard, write, -1)
+	} else {
+		lock.stats.shard(shard).mode(write).contended.Add(1)
+		if lock.hot != nil {
+			lock.hot.current(shard, lock.hot.epoch()).contended.Add(1)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package cxlockrw
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestHistogramBuckets(t *testing.T) {
+	tests := []struct {
+		d      time.Duration
+		bucket int
+	}{
+		{-5, 0},
+		{0, 0},
+		{1, 1},
+		{2, 2},
+		{3, 2},
+		{4, 3},
+		{1023, 10},
+		{1024, 11},
+		{1 << 37, 38},
+		{1 << 38, 39},
+		{1 << 62, HistogramBuckets - 1},
+	}
+	for _, tt := range tests {
+		var h histogramCounters
+		h.record(int64(tt.d))
+		s := h.snapshot()
+		if s.Counts[tt.bucket] != 1 || s.Count() != 1 {
+			t.Errorf("%v landed in %v, want bucket %d", tt.d, s.Counts, tt.bucket)
+		}
+		// Every bucket but the last holds durations below its bound.
+		if tt.bucket < HistogramBuckets-1 && tt.d >= BucketBound(tt.bucket) {
+			t.Errorf("%v is not below BucketBound(%d) = %v", tt.d, tt.bucket, BucketBound(tt.bucket))
+		}
+	}
+}
+
+func TestHistogramQuantileMeanSub(t *testing.T) {
+	var h histogramCounters
+	for i := 0; i < 50; i++ {
+		h.record(1) // bucket 1, below 2ns
+	}
+	for i := 0; i < 50; i++ {
+		h.record(20) // bucket 5, below 32ns
+	}
+	s := h.snapshot()
+	for _, tt := range []struct {
+		q    float64
+		want time.Duration
+	}{{0, 2}, {0.49, 2}, {0.5, 32}, {0.99, 32}, {1, 32}} {
+		if got := s.Quantile(tt.q); got != tt.want {
+			t.Errorf("Quantile(%v) = %v, want %v", tt.q, got, tt.want)
+		}
+	}
+	if got := s.Mean(); got != 10 {
+		t.Errorf("Mean = %v, want 10ns", got)
+	}
+	var empty Histogram
+	if got := empty.Quantile(0.5); got != 0 {
+		t.Errorf("Quantile of an empty histogram = %v, want 0", got)
+	}
+	if got := empty.Mean(); got != 0 {
+		t.Errorf("Mean of an empty histogram = %v, want 0", got)
+	}
+
+	h.record(1000)
+	d := h.snapshot().Sub(s)
+	if d.Count() != 1 || d.Counts[10] != 1 || d.Sum != 1000 {
+		t.Errorf("Sub = %+v, want only the 1000ns duration", d)
+	}
+}
+
+func TestStatsContention(t *testing.T) {
+	eachBackend(t, func(t *testing.T, b Backend) {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		lock := newTestLock(t, b, 2, WithStats())
+		lock.Lock(0)
+		if ok, _ := tryOther(lock, true); ok {
+			t.Fatal("TryLock succeeded on a write-locked shard")
+		}
+		waited := waitingWriter(lock, 0)
+		time.Sleep(blocked)
+		lock.Unlock(0)
+		if err := <-waited; err != nil {
+			t.Fatalf("waiting writer: %v", err)
+		}
+		lock.RLock(1)
+		lock.RUnlock(1)
+
+		st := lock.Stats()
+		w := st[0].Write
+		if w.Acquires != 2 {
+			t.Errorf("write Acquires = %d, want 2", w.Acquires)
+		}
+		// The waiting writer and the failed TryLock.
+		if w.Contended != 2 {
+			t.Errorf("write Contended = %d, want 2", w.Contended)
+		}
+		if w.Wait.Count() != 1 || w.Wait.Quantile(1) < blocked {
+			t.Errorf("write Wait = %d waits up to %v, want 1 of at least %v", w.Wait.Count(), w.Wait.Quantile(1), blocked)
+		}
+		if w.Hold.Count() != 2 {
+			t.Errorf("write Hold count = %d, want 2", w.Hold.Count())
+		}
+		if r := st[1].Read; r.Acquires != 1 || r.Contended != 0 || r.Hold.Count() != 1 {
+			t.Errorf("read stats of shard 1 = %+v, want 1 uncontended acquisition held once", r)
+		}
+	})
+}
+
+// Overlapping readers make up one read-held period.
+func TestStatsReadHoldPeriods(t *testing.T) {
+	lock := newTestLock(t, BackendGo, 1, WithStats())
+	start := time.Now()
+	lock.RLock(0)
+	time.Sleep(blocked)
+	lock.RLock(0)
+	lock.RUnlock(0)
+	time.Sleep(blocked)
+	lock.RUnlock(0)
+	held := time.Since(start)
+
+	hold := lock.Stats()[0].Read.Hold
+	if hold.Count() != 1 {
+		t.Fatalf("read Hold count for overlapping readers = %d, want 1", hold.Count())
+	}
+	if hold.Sum < 2*blocked || hold.Sum > held {
+		t.Errorf("read Hold = %v, want between %v and %v", hold.Sum, 2*blocked, held)
+	}
+
+	lock.RLock(0)
+	lock.RUnlock(0)
+	if n := lock.Stats()[0].Read.Hold.Count(); n != 2 {
+		t.Errorf("read Hold count after a separate reader = %d, want 2", n)
+	}
+	if n := lock.Stats()[0].Read.Acquires; n != 3 {
+		t.Errorf("read Acquires = %d, want 3", n)
+	}
+}
@@ -0,0 +1,132 @@
+package cxlockrw
+
+// An upgradable read lock is a read lock that can later be turned into a
+// write lock without releasing the shard. It shares the shard with plain
+// readers but excludes writers and other upgradable readers, so between
+// UpgradableRLock and Upgrade nothing the holder has read can change.
+//
+// With BackendPthread, upgradable read locks, like write locks, must be
+// released or upgraded by the goroutine that acquired them.
+
+// UpgradableRLock acquires an upgradable read lock for the given shard.
+// Release it with UpgradableRUnlock, or promote it with Upgrade.
+func (lock *ShardedRWLock) UpgradableRLock(shardnum uint32) {
+	must(lock.UpgradableRLockChecked(shardnum))
+}
+
+// UpgradableRUnlock releases an upgradable read lock for the given shard.
+func (lock *ShardedRWLock) UpgradableRUnlock(shardnum uint32) {
+	must(lock.UpgradableRUnlockChecked(shardnum))
+}
+
+// Upgrade turns the caller's upgradable read lock on the given shard into a
+// write lock, waiting for the plain readers to leave. No writer can take the
+// shard in between. Release the result with Unlock.
+func (lock *ShardedRWLock) Upgrade(shardnum uint32) {
+	must(lock.UpgradeChecked(shardnum))
+}
+
+// Downgrade turns the caller's write lock on the given shard into a read
+// lock without letting another writer in. Release the result with RUnlock.
+func (lock *ShardedRWLock) Downgrade(shardnum uint32) {
+	must(lock.DowngradeChecked(shardnum))
+}
+
+// UpgradableRLockChecked acquires an upgradable read lock for the given
+// shard, reporting failures such as ErrDeadlock.
+func (lock *ShardedRWLock) UpgradableRLockChecked(shardnum uint32) error {
+	if err := lock.enter(shardnum); err != nil {
+		return wrapErr("ulock", shardnum, err)
+	}
+	if err := lock.ownerAcquire(shardnum, holdUpgradable); err != nil {
+		lock.leave(shardnum)
+		return wrapErr("ulock", shardnum, err)
+	}
+	depAcquire(lock, shardnum, holdUpgradable)
+	if err := lock.shards.ulock(shardnum); err != nil {
+		lock.leave(shardnum)
+		return wrapErr("ulock", shardnum, err)
+	}
+	lock.ownerAcquired(shardnum, holdUpgradable)
+	depAcquired(lock, shardnum, holdUpgradable)
+	if lock.stats != nil {
+		lock.stats.acquired(shardnum, false, -1)
+	}
+	return nil
+}
+
+// UpgradableRUnlockChecked releases an upgradable read lock for the given
+// shard, reporting failures such as ErrNotHeld.
+func (lock *ShardedRWLock) UpgradableRUnlockChecked(shardnum uint32) error {
+	if lock.closed.Load() {
+		return wrapErr("uunlock", shardnum, ErrClosed)
+	}
+	g, err := lock.ownerRelease(shardnum, holdUpgradable)
+	if err != nil {
+		return wrapErr("uunlock", shardnum, err)
+	}
+	if err := lock.shards.uunlock(shardnum); err != nil {
+		lock.ownerRecord(shardnum, g, holdUpgradable)
+		return wrapErr("uunlock", shardnum, err)
+	}
+	if lock.stats != nil {
+		lock.stats.released(shardnum, false, 0)
+	}
+	depReleased(lock, shardnum, holdUpgradable)
+	lock.leave(shardnum)
+	return nil
+}
+
+// UpgradeChecked is like Upgrade but returns failures such as ErrNotHeld
+// instead of panicking. On failure the upgradable read lock is still held.
+func (lock *ShardedRWLock) UpgradeChecked(shardnum uint32) error {
+	if lock.closed.Load() {
+		return wrapErr("upgrade", shardnum, ErrClosed)
+	}
+	g, err := lock.ownerRelease(shardnum, holdUpgradable)
+	if err != nil {
+		return wrapErr("upgrade", shardnum, err)
+	}
+	depChanged(lock, shardnum, holdUpgradable, holdWrite)
+	if err := lock.shards.upgrade(shardnum); err != nil {
+		lock.ownerRecord(shardnum, g, holdUpgradable)
+		return wrapErr("upgrade", shardnum, err)
+	}
+	lock.ownerRecord(shardnum, g, holdWrite)
+	lock.shards.seq(shardnum).Add(1)
+	if lock.stats != nil {
+		lock.stats.released(shardnum, false, 0)
+		lock.stats.acquired(shardnum, true, -1)
+	}
+	return nil
+}
+
+// DowngradeChecked is like Downgrade but returns failures such as ErrNotHeld
+// instead of panicking.
+func (lock *ShardedRWLock) DowngradeChecked(shardnum uint32) error {
+	if lock.closed.Load() {
+		return wrapErr("downgrade", shardnum, ErrClosed)
+	}
+	g, err := lock.ownerRelease(shardnum, holdWrite)
+	if err != nil {
+		return wrapErr("downgrade", shardnum, err)
+	}
+	var since int64
+	if lock.stats != nil {
+		since = lock.stats.shard(shardnum).writeSince.Load()
+	}
+	seq := lock.shards.seq(shardnum)
+	seq.Add(1)
+	if err := lock.shards.downgrade(shardnum); err != nil {
+		seq.Add(^uint64(0))
+		lock.ownerRecord(shardnum, g, holdWrite)
+		return wrapErr("downgrade", shardnum, err)
+	}
+	lock.ownerRecord(shardnum, g, holdRead)
+	if lock.stats != nil {
+		lock.stats.released(shardnum, true, since)
+		lock.stats.acquired(shardnum, false, -1)
+	}
+	depChanged(lock, shardnum, holdWrite, holdRead)
+	return nil
+}
@@ -8,17 +8,52 @@ package posixsem
 #include <stdlib.h> 
 #include <semaphore.h>
 #include <errno.h>
+#include <time.h>
 
 sem_t *sem_open_wrapper(const char *name, int oflag, mode_t mode, unsigned int value) {
     return sem_open(name, oflag, mode, value);
 }
+
+// Waits until the absolute CLOCK_REALTIME deadline; returns 0 or an errno
+// value. macOS has no sem_timedwait, so poll sem_trywait there.
+int sem_timedwait_wrapper(sem_t *sem, long long deadline_ns) {
+#if defined(__APPLE__)
+    struct timespec pause = {0, 50000};
+    for (;;) {
+        if (sem_trywait(sem) == 0) {
+            return 0;
+        }
+        if (errno != EAGAIN) {
+            return errno;
+        }
+        struct timespec now;
+        clock_gettime(CLOCK_REALTIME, &now);
+        if ((long long)now.tv_sec * 1000000000LL + now.tv_nsec >= deadline_ns) {
+            return ETIMEDOUT;
+        }
+        nanosleep(&pause, NULL);
+    }
+#else
+    struct timespec ts;
+    ts.tv_sec = deadline_ns / 1000000000LL;
+    ts.tv_nsec = deadline_ns % 1000000000LL;
+    return sem_timedwait(sem, &ts) == 0 ? 0 : errno;
+#endif
+}
 */
 import "C"
 import (
+    "context"
     "errors"
+    "syscall"
+    "time"
     "unsafe"
 )
 
+// pollInterval bounds each timed wait so that a context cancelled without a
+// deadline is noticed promptly.
+const pollInterval = 10 * time.Millisecond
+
 // Sem represents a named semaphore.
 type Sem struct {
     name *C.char
@@ -40,10 +75,67 @@ func Open(name string, value uint) (*Sem, error) {
 
 // Wait decreases the semaphore value (lock/wait).
 func (s *Sem) Wait() error {
-    if C.sem_wait(s.sem) == -1 {
-        return errors.New("failed to wait on semaphore")
+    for {
+        rc, err := C.sem_wait(s.sem)
+        if rc == 0 {
+            return nil
+        }
+        // sem_wait is never restarted after a signal, and the Go runtime
+        // signals threads to preempt goroutines.
+        if err != syscall.EINTR {
+            return errors.New("failed to wait on semaphore")
+        }
+    }
+}
+
+// TryWait decreases the semaphore value if that is possible without
+// waiting, and reports whether it did.
+func (s *Sem) TryWait() (bool, error) {
+    for {
+        rc, err := C.sem_trywait(s.sem)
+        if rc == 0 {
+            return true, nil
+        }
+        switch err {
+        case syscall.EAGAIN:
+            return false, nil
+        case syscall.EINTR:
+            continue
+        }
+        return false, errors.New("failed to wait on semaphore")
+    }
+}
+
+// WaitContext decreases the semaphore value, waiting until ctx is done.
+// It returns ctx.Err() if the value could not be decreased in time.
+func (s *Sem) WaitContext(ctx context.Context) error {
+    if err := ctx.Err(); err != nil {
+        return err
+    }
+    if ctx.Done() == nil {
+        return s.Wait()
+    }
+    ctxDeadline, hasDeadline := ctx.Deadline()
+    for {
+        deadline := time.Now().Add(pollInterval)
+        if hasDeadline && ctxDeadline.Before(deadline) {
+            deadline = ctxDeadline
+        }
+        switch rc := C.sem_timedwait_wrapper(s.sem, C.longlong(deadline.UnixNano())); rc {
+        case 0:
+            return nil
+        case C.EINTR:
+        case C.ETIMEDOUT:
+            if err := ctx.Err(); err != nil {
+                return err
+            }
+            if hasDeadline && !time.Now().Before(ctxDeadline) {
+                return context.DeadlineExceeded
+            }
+        default:
+            return errors.New("failed to wait on semaphore")
+        }
     }
-    return nil
 }
 
 
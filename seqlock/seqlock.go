@@ -0,0 +1,72 @@
+// Package cxlockseq provides sequence locks for data that is written rarely
+// and read often. Readers never write shared memory: they sample a sequence
+// number, read, and retry if a writer was active in between.
+package cxlockseq
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// SeqLock is a sequence lock. Its sequence is odd while a writer is inside
+// BeginWrite/EndWrite and is bumped on both calls. Writers are serialized by
+// an internal mutex, so more than one writer is allowed but they do not run
+// concurrently.
+//
+// Reads that race with a writer are detected but not prevented, so the data
+// it guards must be read with sync/atomic, or copied out and used only once
+// Retry has returned false. Value does this for pointer-free types.
+//
+// The zero value is an unlocked SeqLock.
+type SeqLock struct {
+	mu  sync.Mutex
+	seq atomic.Uint64
+}
+
+// BeginWrite starts a write section, waiting for any other writer.
+func (l *SeqLock) BeginWrite() {
+	l.mu.Lock()
+	l.seq.Add(1)
+}
+
+// EndWrite ends the write section started by BeginWrite.
+func (l *SeqLock) EndWrite() {
+	l.seq.Add(1)
+	l.mu.Unlock()
+}
+
+// BeginRead returns the sequence to pass to Retry, waiting while a writer
+// is active.
+func (l *SeqLock) BeginRead() uint64 {
+	for {
+		if seq := l.seq.Load(); seq&1 == 0 {
+			return seq
+		}
+		runtime.Gosched()
+	}
+}
+
+// Retry reports whether a writer has been active since BeginRead returned
+// seq, in which case everything read since then must be discarded.
+func (l *SeqLock) Retry(seq uint64) bool {
+	return l.seq.Load() != seq
+}
+
+// Read calls read until it runs without a concurrent writer.
+func (l *SeqLock) Read(read func()) {
+	for {
+		seq := l.BeginRead()
+		read()
+		if !l.Retry(seq) {
+			return
+		}
+	}
+}
+
+// Write calls write inside BeginWrite/EndWrite.
+func (l *SeqLock) Write(write func()) {
+	l.BeginWrite()
+	defer l.EndWrite()
+	write()
+}
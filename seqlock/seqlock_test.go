@@ -0,0 +1,62 @@
+package cxlockseq_test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	cxlockseq "github.com/cloudxaas/golock/seqlock"
+)
+
+func TestRetry(t *testing.T) {
+	var l cxlockseq.SeqLock
+	seq := l.BeginRead()
+	if l.Retry(seq) {
+		t.Fatal("Retry without a writer = true")
+	}
+	l.Write(func() {})
+	if !l.Retry(seq) {
+		t.Fatal("Retry after a write = false")
+	}
+	if seq = l.BeginRead(); l.Retry(seq) {
+		t.Fatal("Retry for a sequence read after the write = true")
+	}
+}
+
+// Read must only return from a run that no writer overlapped, so it never
+// sees the two halves of a write disagree.
+func TestReadConcurrentWriter(t *testing.T) {
+	var (
+		l    cxlockseq.SeqLock
+		a, b atomic.Int64
+	)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := int64(1); ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			l.Write(func() {
+				a.Store(i)
+				b.Store(i)
+			})
+		}
+	}()
+	for i := 0; i < 10000; i++ {
+		var x, y int64
+		l.Read(func() {
+			x = a.Load()
+			y = b.Load()
+		})
+		if x != y {
+			t.Fatalf("Read saw a torn write: %d, %d", x, y)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
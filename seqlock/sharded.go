@@ -0,0 +1,110 @@
+package cxlockseq
+
+import (
+	"errors"
+	"unsafe"
+
+	"github.com/cloudxaas/golock/internal/shardhash"
+)
+
+// Hasher maps keys to 64-bit hashes used to select a shard. It is the same
+// type as cxlockrw.Hasher, so a ShardedSeqLock and a ShardedRWLock with the
+// same shard count and hasher put every key in the same shard.
+type Hasher = shardhash.Hasher
+
+// FNV1a is the default Hasher, the same as cxlockrw.FNV1a.
+type FNV1a = shardhash.FNV1a
+
+// Option configures a ShardedSeqLock.
+type Option func(*config)
+
+type config struct {
+	hasher Hasher
+}
+
+// WithHasher sets the Hasher used to map keys to shards.
+// The default is FNV1a. A nil Hasher is ignored, as by cxlockrw.WithHasher.
+func WithHasher(h Hasher) Option {
+	return func(c *config) {
+		if h != nil {
+			c.hasher = h
+		}
+	}
+}
+
+// cacheLine is the size shards are padded to.
+const cacheLine = 64
+
+// paddedSeqLock is a SeqLock on its own cache line.
+type paddedSeqLock struct {
+	SeqLock
+	_ [(cacheLine - unsafe.Sizeof(SeqLock{})%cacheLine) % cacheLine]byte
+}
+
+// ShardedSeqLock is a set of SeqLocks indexed like the shards of a
+// cxlockrw.ShardedRWLock.
+type ShardedSeqLock struct {
+	shards []paddedSeqLock
+	hasher Hasher
+}
+
+// NewShardedSeqLock creates a ShardedSeqLock with numShards shards.
+// It panics if numShards is not positive.
+func NewShardedSeqLock(numShards int, opts ...Option) *ShardedSeqLock {
+	if numShards <= 0 {
+		panic(errors.New("cxlockseq: numShards must be positive"))
+	}
+	cfg := config{hasher: FNV1a{}}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return &ShardedSeqLock{
+		shards: make([]paddedSeqLock, numShards),
+		hasher: cfg.hasher,
+	}
+}
+
+// NumShards returns the number of shards.
+func (lock *ShardedSeqLock) NumShards() int {
+	return len(lock.shards)
+}
+
+// Shard returns the SeqLock for a shard index.
+func (lock *ShardedSeqLock) Shard(shardnum uint32) *SeqLock {
+	return &lock.shards[shardnum].SeqLock
+}
+
+// shardOf reduces a hash to a shard index.
+func (lock *ShardedSeqLock) shardOf(hash uint64) uint32 {
+	return uint32(hash % uint64(len(lock.shards)))
+}
+
+// ShardOf returns the shard index used for a string key.
+func (lock *ShardedSeqLock) ShardOf(key string) uint32 {
+	return lock.shardOf(lock.hasher.HashString(key))
+}
+
+// ShardOfBytes returns the shard index used for a byte slice key.
+func (lock *ShardedSeqLock) ShardOfBytes(key []byte) uint32 {
+	return lock.shardOf(lock.hasher.HashBytes(key))
+}
+
+// ShardOfUint64 returns the shard index used for an integer key.
+func (lock *ShardedSeqLock) ShardOfUint64(key uint64) uint32 {
+	return lock.shardOf(lock.hasher.HashUint64(key))
+}
+
+// Key returns the SeqLock for a string key.
+func (lock *ShardedSeqLock) Key(key string) *SeqLock {
+	return lock.Shard(lock.ShardOf(key))
+}
+
+// Bytes returns the SeqLock for a byte slice key.
+func (lock *ShardedSeqLock) Bytes(key []byte) *SeqLock {
+	return lock.Shard(lock.ShardOfBytes(key))
+}
+
+// Uint64 returns the SeqLock for an integer key.
+func (lock *ShardedSeqLock) Uint64(key uint64) *SeqLock {
+	return lock.Shard(lock.ShardOfUint64(key))
+}
@@ -0,0 +1,24 @@
+package cxlockseq_test
+
+import (
+	"strconv"
+	"testing"
+
+	cxlockrw "github.com/cloudxaas/golock/rw"
+	cxlockseq "github.com/cloudxaas/golock/seqlock"
+)
+
+// A ShardedSeqLock must put every key in the same shard as a ShardedRWLock
+// configured the same way, including with a nil Hasher.
+func TestShardOfMatchesRW(t *testing.T) {
+	const numShards = 16
+	seq := cxlockseq.NewShardedSeqLock(numShards, cxlockseq.WithHasher(nil))
+	rw := cxlockrw.NewShardedRWLock(numShards, cxlockrw.WithHasher(nil), cxlockrw.WithBackend(cxlockrw.BackendGo))
+	defer rw.Close()
+	for i := 0; i < 100; i++ {
+		key := "key" + strconv.Itoa(i)
+		if got, want := seq.ShardOf(key), rw.ShardOf(key); got != want {
+			t.Fatalf("ShardOf(%q) = %d, want %d as in cxlockrw", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package cxlockseq
+
+import (
+	"reflect"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Value holds a T that is read consistently without locking. Load and Store
+// copy the value word by word with atomic operations under a SeqLock, so a
+// Load never returns a mix of two stores and the race detector stays quiet.
+//
+// T must not contain pointers, strings, slices, maps, channels, functions or
+// interfaces: the copies are plain words that the garbage collector cannot
+// see into.
+type Value[T any] struct {
+	lock  SeqLock
+	words []uint64
+}
+
+// NewValue returns a Value holding v. It panics if T contains pointers.
+func NewValue[T any](v T) *Value[T] {
+	typ := reflect.TypeOf(&v).Elem()
+	if hasPointers(typ) {
+		panic("cxlockseq: Value of " + typ.String() + ", which contains pointers")
+	}
+	size := unsafe.Sizeof(v)
+	val := &Value[T]{words: make([]uint64, (size+7)/8)}
+	val.Store(v)
+	return val
+}
+
+// Load returns a consistent copy of the value.
+func (val *Value[T]) Load() T {
+	var v T
+	for {
+		seq := val.lock.BeginRead()
+		val.copyOut(bytesOf(&v))
+		if !val.lock.Retry(seq) {
+			return v
+		}
+	}
+}
+
+// Store replaces the value. Concurrent Stores are serialized.
+func (val *Value[T]) Store(v T) {
+	val.lock.BeginWrite()
+	val.copyIn(bytesOf(&v))
+	val.lock.EndWrite()
+}
+
+// Update replaces the value with f applied to it. Concurrent Stores and
+// Updates are serialized, so no update is lost. f must not use val.
+func (val *Value[T]) Update(f func(T) T) {
+	val.lock.BeginWrite()
+	defer val.lock.EndWrite()
+	var v T
+	val.copyOut(bytesOf(&v))
+	v = f(v)
+	val.copyIn(bytesOf(&v))
+}
+
+// copyOut loads the stored words into dst.
+func (val *Value[T]) copyOut(dst []byte) {
+	for i := range val.words {
+		w := atomic.LoadUint64(&val.words[i])
+		copy(dst[i*8:], (*[8]byte)(unsafe.Pointer(&w))[:])
+	}
+}
+
+// copyIn stores src into the words.
+func (val *Value[T]) copyIn(src []byte) {
+	for i := range val.words {
+		var w uint64
+		copy((*[8]byte)(unsafe.Pointer(&w))[:], src[i*8:])
+		atomic.StoreUint64(&val.words[i], w)
+	}
+}
+
+// bytesOf returns the memory of *p as a byte slice.
+func bytesOf[T any](p *T) []byte {
+	return unsafe.Slice((*byte)(unsafe.Pointer(p)), unsafe.Sizeof(*p))
+}
+
+// hasPointers reports whether values of typ contain anything the garbage
+// collector must trace.
+func hasPointers(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.Array:
+		return typ.Len() > 0 && hasPointers(typ.Elem())
+	case reflect.Struct:
+		for i := 0; i < typ.NumField(); i++ {
+			if hasPointers(typ.Field(i).Type) {
+				return true
+			}
+		}
+		return false
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,88 @@
+package cxlockseq_test
+
+import (
+	"sync"
+	"testing"
+
+	cxlockseq "github.com/cloudxaas/golock/seqlock"
+)
+
+// point is 19 bytes, so its last word is only partly used.
+type point struct {
+	X, Y int64
+	Tag  [3]byte
+}
+
+func TestValueRoundTrip(t *testing.T) {
+	v := cxlockseq.NewValue(point{1, 2, [3]byte{'a', 'b', 'c'}})
+	if got, want := v.Load(), (point{1, 2, [3]byte{'a', 'b', 'c'}}); got != want {
+		t.Fatalf("Load after NewValue = %v, want %v", got, want)
+	}
+	v.Store(point{X: -3, Y: 4})
+	if got, want := v.Load(), (point{X: -3, Y: 4}); got != want {
+		t.Fatalf("Load after Store = %v, want %v", got, want)
+	}
+	v.Update(func(p point) point {
+		p.X++
+		p.Tag[2] = 'z'
+		return p
+	})
+	if got, want := v.Load(), (point{X: -2, Y: 4, Tag: [3]byte{2: 'z'}}); got != want {
+		t.Fatalf("Load after Update = %v, want %v", got, want)
+	}
+}
+
+func TestValueConcurrent(t *testing.T) {
+	const goroutines, updates = 4, 1000
+	v := cxlockseq.NewValue(point{})
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < updates; j++ {
+				v.Update(func(p point) point {
+					p.X++
+					p.Y--
+					return p
+				})
+			}
+		}()
+	}
+	for i := 0; i < updates; i++ {
+		if p := v.Load(); p.X != -p.Y {
+			t.Fatalf("Load saw a torn update: %v", p)
+		}
+	}
+	wg.Wait()
+	if got, want := v.Load(), (point{X: goroutines * updates, Y: -goroutines * updates}); got != want {
+		t.Fatalf("Load after the updates = %v, want %v", got, want)
+	}
+}
+
+func TestNewValueRejectsPointers(t *testing.T) {
+	mustPanic := func(name string, f func()) {
+		t.Helper()
+		defer func() {
+			if recover() == nil {
+				t.Errorf("NewValue of %s did not panic", name)
+			}
+		}()
+		f()
+	}
+	mustPanic("*int", func() { cxlockseq.NewValue(new(int)) })
+	mustPanic("string", func() { cxlockseq.NewValue("") })
+	mustPanic("[]byte", func() { cxlockseq.NewValue([]byte(nil)) })
+	mustPanic("map", func() { cxlockseq.NewValue(map[int]int(nil)) })
+	mustPanic("chan", func() { cxlockseq.NewValue(chan int(nil)) })
+	mustPanic("func", func() { cxlockseq.NewValue(func() {}) })
+	mustPanic("interface", func() { cxlockseq.NewValue[any](nil) })
+	mustPanic("nested pointer", func() {
+		cxlockseq.NewValue(struct {
+			N int
+			P [2]*int
+		}{})
+	})
+	cxlockseq.NewValue([4]int32{})
+	cxlockseq.NewValue(struct{ A, B float64 }{})
+}